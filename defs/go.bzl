@@ -17,12 +17,13 @@
 load("@bazel_skylib//lib:paths.bzl", "paths")
 load("@io_bazel_rules_go//go:def.bzl", "GoArchive", "GoLibrary", "go_context")
 
-def _compute_genrule_variables(srcs, outs):
+def _compute_genrule_variables(srcs, outs, importcfg):
     resolved_srcs = [src.path for src in srcs]
     resolved_outs = [out.path for out in outs]
     variables = {
         "SRCS": " ".join(resolved_srcs),
         "OUTS": " ".join(resolved_outs),
+        "GO_IMPORTCFG": importcfg.path,
     }
     if len(resolved_srcs) == 1:
         variables["<"] = resolved_srcs[0]
@@ -61,6 +62,40 @@ def _go_genrule_impl(ctx):
 
             gopath_files.append(target)
 
+    # An importcfg mapping each of go_deps, and their dependencies, to its
+    # compiled export data, so that tools can type-check generated code
+    # against them.
+    export_files = []
+    importcfg_lines = []
+    for lib in transitive_libs.to_list():
+        export_file = getattr(lib, "export_file", None) or lib.file
+        export_files.append(export_file)
+        importcfg_lines.append("packagefile %s=%s" % (lib.importmap, export_file.path))
+        if lib.importmap != lib.importpath:
+            importcfg_lines.append("importmap %s=%s" % (lib.importpath, lib.importmap))
+    deps_importcfg = ctx.actions.declare_file(ctx.label.name + ".deps.importcfg")
+    ctx.actions.write(deps_importcfg, "\n".join(importcfg_lines + [""]))
+
+    # The standard library is the export data rules_go builds for the
+    # target mode, under go.stdlib.root_file, rather than the SDK's own pkg
+    # directory, which may have been built for another mode. Its packages
+    # are listed from the SDK's package list when the action runs, as
+    # rules_go does for linking.
+    installsuffix = go.mode.goos + "_" + go.mode.goarch
+    if go.mode.race:
+        installsuffix += "_race"
+    elif go.mode.msan:
+        installsuffix += "_msan"
+    stdlib_dir = paths.join(go.stdlib.root_file.dirname, "pkg", installsuffix)
+    importcfg = ctx.actions.declare_file(ctx.label.name + ".importcfg")
+    ctx.actions.run_shell(
+        inputs = [deps_importcfg, go.sdk.package_list],
+        outputs = [importcfg],
+        command = "cat \"$1\" >\"$4\" && while read -r pkg; do [ -z \"$pkg\" ] || echo \"packagefile $pkg=$3/$pkg.a\"; done <\"$2\" >>\"$4\"",
+        arguments = [deps_importcfg.path, go.sdk.package_list.path, stdlib_dir, importcfg.path],
+        mnemonic = "GoGenruleImportcfg",
+    )
+
     srcs = [src for srcs in ctx.attr.srcs for src in srcs.files.to_list()]
 
     inputs, cmd, input_manifests = ctx.resolve_command(
@@ -70,17 +105,18 @@ def _go_genrule_impl(ctx):
         make_variables = _compute_genrule_variables(
             srcs,
             ctx.outputs.outs,
+            importcfg,
         ),
         tools = ctx.attr.tools,
     )
 
     deps = depset(
-        gopath_files + srcs + inputs,
+        gopath_files + export_files + [importcfg] + srcs + inputs,
         transitive =
             # tools
             [dep.files for dep in ctx.attr.tools] +
             # go toolchain
-            [depset(go.sdk.libs + go.sdk.srcs + go.sdk.tools + [go.sdk.go])],
+            [depset(go.sdk.libs + go.sdk.srcs + go.sdk.tools + [go.sdk.go] + go.stdlib.libs)],
     )
 
     env = dict()
@@ -110,6 +146,9 @@ def _go_genrule_impl(ctx):
 # and thus depend on executing with a valid GOROOT. _go_genrule handles
 # dependencies on the Go toolchain and environment variables; the
 # macro go_genrule handles setting up GOPATH dependencies (using go_path).
+# $(GO_IMPORTCFG) in cmd expands to an importcfg file listing the export
+# data of go_deps and the standard library, e.g. for //defs/testgen's
+# -importcfg flag.
 go_genrule = rule(
     _go_genrule_impl,
     attrs = {
@@ -130,6 +169,10 @@ go_genrule = rule(
         "_go_context_data": attr.label(
             default = "@io_bazel_rules_go//:go_context_data",
         ),
+        # Sets go.stdlib, the standard library built for the target mode.
+        "_stdlib": attr.label(
+            default = "@io_bazel_rules_go//:stdlib",
+        ),
     },
     toolchains = ["@io_bazel_rules_go//go:toolchain"],
     output_to_genfiles = True,
@@ -0,0 +1,216 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// apiFeatures describes the exported API of pkg, one feature per line, in
+// the style of the Go project's api files:
+//
+//	pkg example.com/p, const Max untyped int = 10
+//	pkg example.com/p, func New(string, ...Option) (*Client, error)
+//	pkg example.com/p, method (*Client) Close() error
+//	pkg example.com/p, type Client struct
+//	pkg example.com/p, type Client struct, Timeout time.Duration
+//	pkg example.com/p, func Map[T comparable](T) T
+//	pkg example.com/p, method (*List[T]) Push(T)
+//
+// Parameter names are left out, since changing them is compatible. The
+// features are sorted and unique.
+func apiFeatures(pkg *types.Package) []string {
+	prefix := "pkg " + pkg.Path() + ", "
+	qualifier := func(other *types.Package) string {
+		if other == pkg {
+			return ""
+		}
+		return other.Path()
+	}
+	typeString := func(t types.Type) string {
+		return types.TypeString(t, qualifier)
+	}
+
+	seen := map[string]bool{}
+	add := func(format string, args ...interface{}) {
+		seen[prefix+fmt.Sprintf(format, args...)] = true
+	}
+
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.Const:
+			add("const %s %s = %s", name, typeString(obj.Type()), obj.Val().ExactString())
+		case *types.Var:
+			add("var %s %s", name, typeString(obj.Type()))
+		case *types.Func:
+			sig := obj.Type().(*types.Signature)
+			add("func %s%s%s", name, typeParamList(sig, typeString, true), signatureString(sig, typeString))
+		case *types.TypeName:
+			if obj.IsAlias() {
+				add("type %s = %s", name, typeString(aliasTarget(obj.Type())))
+				continue
+			}
+			// Generic types are named with their type parameters, and
+			// receivers with just their names.
+			recvName := name + typeParamList(obj.Type(), typeString, false)
+			name := name + typeParamList(obj.Type(), typeString, true)
+			switch u := obj.Type().Underlying().(type) {
+			case *types.Struct:
+				add("type %s struct", name)
+				for i := 0; i < u.NumFields(); i++ {
+					if f := u.Field(i); f.Exported() {
+						if f.Embedded() {
+							add("type %s struct, embedded %s", name, typeString(f.Type()))
+						} else {
+							add("type %s struct, %s %s", name, f.Name(), typeString(f.Type()))
+						}
+					}
+				}
+			case *types.Interface:
+				add("type %s interface", name)
+				for i := 0; i < u.NumMethods(); i++ {
+					if m := u.Method(i); m.Exported() {
+						add("type %s interface, %s%s", name, m.Name(), signatureString(m.Type().(*types.Signature), typeString))
+					} else {
+						// Unexported methods keep other packages from
+						// implementing the interface.
+						add("type %s interface, unexported methods", name)
+					}
+				}
+				continue
+			default:
+				add("type %s %s", name, typeString(u))
+			}
+			// The method set of *T includes the methods of T and those
+			// promoted from embedded fields.
+			mset := types.NewMethodSet(types.NewPointer(obj.Type()))
+			for i := 0; i < mset.Len(); i++ {
+				m := mset.At(i).Obj()
+				if !m.Exported() {
+					continue
+				}
+				recv := recvName
+				if _, ptr := m.Type().(*types.Signature).Recv().Type().(*types.Pointer); ptr && len(mset.At(i).Index()) == 1 {
+					recv = "*" + recvName
+				}
+				add("method (%s) %s%s", recv, m.Name(), signatureString(m.Type().(*types.Signature), typeString))
+			}
+		}
+	}
+
+	features := make([]string, 0, len(seen))
+	for f := range seen {
+		features = append(features, f)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// aliasTarget returns the type an alias denotes. Newer versions of
+// go/types represent aliases as types of their own, which print as the
+// alias name, so they are followed to the type they refer to.
+func aliasTarget(t types.Type) types.Type {
+	for {
+		alias, ok := t.(interface{ Rhs() types.Type })
+		if !ok {
+			return t
+		}
+		t = alias.Rhs()
+	}
+}
+
+// signatureString formats sig without parameter names or the func
+// keyword, e.g. "(string, ...int) (bool, error)".
+func signatureString(sig *types.Signature, typeString func(types.Type) string) string {
+	var params []string
+	for i := 0; i < sig.Params().Len(); i++ {
+		t := sig.Params().At(i).Type()
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			params = append(params, "..."+typeString(t.(*types.Slice).Elem()))
+			continue
+		}
+		params = append(params, typeString(t))
+	}
+	s := "(" + strings.Join(params, ", ") + ")"
+
+	var results []string
+	for i := 0; i < sig.Results().Len(); i++ {
+		results = append(results, typeString(sig.Results().At(i).Type()))
+	}
+	switch len(results) {
+	case 0:
+	case 1:
+		s += " " + results[0]
+	default:
+		s += " (" + strings.Join(results, ", ") + ")"
+	}
+	return s
+}
+
+// compareAPI compares the features of an API with those of its golden
+// version. Removing or changing a feature is incompatible, and so is
+// adding a method to an existing interface, which breaks its
+// implementations. Other additions are compatible.
+func compareAPI(golden, current []string) (incompatible, compatible []string) {
+	inGolden := map[string]bool{}
+	for _, f := range golden {
+		inGolden[f] = true
+	}
+	inCurrent := map[string]bool{}
+	for _, f := range current {
+		inCurrent[f] = true
+	}
+
+	for _, f := range golden {
+		if !inCurrent[f] {
+			incompatible = append(incompatible, "- "+f)
+		}
+	}
+	for _, f := range current {
+		if inGolden[f] {
+			continue
+		}
+		if i := strings.Index(f, " interface, "); i >= 0 && inGolden[f[:i]+" interface"] {
+			incompatible = append(incompatible, "+ "+f)
+		} else {
+			compatible = append(compatible, "+ "+f)
+		}
+	}
+	return incompatible, compatible
+}
+
+// parseAPI returns the features of an API file, skipping blank lines and
+// "#" comments.
+func parseAPI(data string) []string {
+	var features []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		features = append(features, line)
+	}
+	return features
+}
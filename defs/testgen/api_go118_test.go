@@ -0,0 +1,84 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPIFeaturesTypeParams(t *testing.T) {
+	got := checkAPI(t, `package p
+
+type Number interface {
+	~int | ~float64
+}
+
+type List[T any] struct {
+	Head *T
+	next *List[T]
+}
+
+func (l *List[T]) Push(v T) {}
+
+type Pair[K comparable, V Number] map[K]V
+
+func Map[T comparable](x T) T { return x }
+
+func Sum[S ~[]E, E Number](s S) E { return 0 }
+`)
+	want := []string{
+		"pkg example.com/p, func Map[T comparable](T) T",
+		"pkg example.com/p, func Sum[S ~[]E, E Number](S) E",
+		"pkg example.com/p, method (*List[T]) Push(T)",
+		"pkg example.com/p, type List[T any] struct",
+		"pkg example.com/p, type List[T any] struct, Head *T",
+		"pkg example.com/p, type Number interface",
+		"pkg example.com/p, type Pair[K comparable, V Number] map[K]V",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got features\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTestpkgGenericAPI(t *testing.T) {
+	// go_genrule_api checks pkg.go against api.txt. generic.go needs Go
+	// 1.18, so its features are listed apart, in api_go1.18.txt.
+	fset := token.NewFileSet()
+	files, err := parseFiles(fset, []string{"../testpkg/generic.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("k8s.io/repo-infra/defs/testpkg", fset, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("../testpkg/api_go1.18.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := apiFeatures(pkg), parseAPI(string(data)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got features\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func checkAPI(t *testing.T, src string) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("example.com/p", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apiFeatures(pkg)
+}
+
+func TestAPIFeatures(t *testing.T) {
+	got := checkAPI(t, `package p
+
+const Max = 10
+const name = "p"
+
+var Default *Client
+
+type Client struct {
+	Timeout int
+	Base
+	secret  string
+}
+
+type Base struct{}
+
+func (Base) Name() string { return name }
+
+func (c *Client) Do(req string, opts ...int) (int, error) { return 0, nil }
+func (c *Client) retry() {}
+
+type Doer interface {
+	Do(string, ...int) (int, error)
+}
+
+type Sealed interface {
+	Doer
+	seal()
+}
+
+type Mode uint8
+
+type Alias = Client
+
+func New(base string) *Client { return nil }
+func helper() {}
+`)
+	want := []string{
+		"pkg example.com/p, const Max untyped int = 10",
+		"pkg example.com/p, func New(string) *Client",
+		"pkg example.com/p, method (*Client) Do(string, ...int) (int, error)",
+		"pkg example.com/p, method (Base) Name() string",
+		"pkg example.com/p, method (Client) Name() string",
+		"pkg example.com/p, type Alias = Client",
+		"pkg example.com/p, type Base struct",
+		"pkg example.com/p, type Client struct",
+		"pkg example.com/p, type Client struct, Timeout int",
+		"pkg example.com/p, type Client struct, embedded Base",
+		"pkg example.com/p, type Doer interface",
+		"pkg example.com/p, type Doer interface, Do(string, ...int) (int, error)",
+		"pkg example.com/p, type Mode uint8",
+		"pkg example.com/p, type Sealed interface",
+		"pkg example.com/p, type Sealed interface, Do(string, ...int) (int, error)",
+		"pkg example.com/p, type Sealed interface, unexported methods",
+		"pkg example.com/p, var Default *Client",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got features\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCompareAPI(t *testing.T) {
+	golden := parseAPI(`# API of example.com/p
+pkg p, func New(string) *Client
+pkg p, func Old()
+pkg p, type Doer interface
+pkg p, type Doer interface, Do(string) error
+
+pkg p, type Client struct
+`)
+	current := []string{
+		"pkg p, func New(string, ...int) *Client",
+		"pkg p, type Client struct",
+		"pkg p, type Client struct, Timeout int",
+		"pkg p, type Doer interface",
+		"pkg p, type Doer interface, Close() error",
+		"pkg p, type Doer interface, Do(string) error",
+		"pkg p, type Runner interface",
+		"pkg p, type Runner interface, Run()",
+	}
+	incompatible, compatible := compareAPI(golden, current)
+	if want := []string{
+		"- pkg p, func New(string) *Client",
+		"- pkg p, func Old()",
+		"+ pkg p, type Doer interface, Close() error",
+	}; !reflect.DeepEqual(incompatible, want) {
+		t.Errorf("incompatible = %q; want %q", incompatible, want)
+	}
+	if want := []string{
+		"+ pkg p, func New(string, ...int) *Client",
+		"+ pkg p, type Client struct, Timeout int",
+		"+ pkg p, type Runner interface",
+		"+ pkg p, type Runner interface, Run()",
+	}; !reflect.DeepEqual(compatible, want) {
+		t.Errorf("compatible = %q; want %q", compatible, want)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"go/ast"
@@ -24,32 +25,179 @@ import (
 	"go/parser"
 	"go/token"
 	"go/types"
+	"io"
 	"io/ioutil"
 	"log"
+	"os"
+	"strings"
 )
 
 var (
-	in      = flag.String("in", "", "input")
-	out     = flag.String("out", "", "output")
-	pkgName = flag.String("pkg", "", "package")
+	in        multiString
+	out       = flag.String("out", "", "output")
+	pkgName   = flag.String("pkg", "", "package")
+	importcfg = flag.String("importcfg", "", "importcfg file mapping import paths to export data, as written by go_genrule for go_deps")
+	mode      = flag.String("mode", "ok", "what to write to -out: ok, a file that compiles if the package type-checks; api, the exported API of the package; or compare, like ok but also failing if the API changed incompatibly from -golden")
+	golden    = flag.String("golden", "", "API file, as written by -mode=api, to compare the package against in -mode=compare")
 )
 
 func main() {
+	flag.Var(&in, "in", "input file of the package; may be repeated, and further inputs may be given as arguments")
 	flag.Parse()
 
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, *in, nil, 0)
+	files, err := parseFiles(fset, append(in, flag.Args()...))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	conf := types.Config{Importer: importer.Default()}
+	imp := importer.Default()
+	if *importcfg != "" {
+		cfg, err := readImportcfg(*importcfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		imp = cfg.importer(fset)
+	}
+	conf := types.Config{Importer: imp}
 
-	pkg, err := conf.Check(*pkgName, fset, []*ast.File{f}, nil)
+	pkg, err := conf.Check(*pkgName, fset, files, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := ioutil.WriteFile(*out, []byte(fmt.Sprintf("package %s\nconst OK = true", pkg.Name())), 0666); err != nil {
+
+	output := fmt.Sprintf("package %s\nconst OK = true", pkg.Name())
+	switch *mode {
+	case "ok":
+	case "api":
+		output = strings.Join(apiFeatures(pkg), "\n") + "\n"
+	case "compare":
+		if *golden == "" {
+			log.Fatal("-mode=compare needs -golden")
+		}
+		data, err := ioutil.ReadFile(*golden)
+		if err != nil {
+			log.Fatal(err)
+		}
+		incompatible, compatible := compareAPI(parseAPI(string(data)), apiFeatures(pkg))
+		for _, f := range compatible {
+			log.Printf("compatible API change: %s", f)
+		}
+		if len(incompatible) > 0 {
+			log.Fatalf("incompatible API changes from %s:\n%s", *golden, strings.Join(incompatible, "\n"))
+		}
+	default:
+		log.Fatalf("unknown -mode %q; want ok, api or compare", *mode)
+	}
+	if err := ioutil.WriteFile(*out, []byte(output), 0666); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// parseFiles parses the files of a package.
+func parseFiles(fset *token.FileSet, paths []string) ([]*ast.File, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input files")
+	}
+	var files []*ast.File
+	for _, path := range paths {
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// importCfg is an importcfg file, in the format read by the compiler.
+type importCfg struct {
+	name string
+	// packageFiles maps import paths to export data files.
+	packageFiles map[string]string
+	// importMap maps import paths as written to the paths of packageFiles,
+	// e.g. for vendored packages.
+	importMap map[string]string
+}
+
+// readImportcfg reads an importcfg file of "packagefile path=file" and
+// "importmap old=new" lines.
+func readImportcfg(path string) (*importCfg, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseImportcfg(f, path)
+}
+
+func parseImportcfg(r io.Reader, name string) (*importCfg, error) {
+	cfg := &importCfg{
+		name:         name,
+		packageFiles: map[string]string{},
+		importMap:    map[string]string{},
+	}
+	s := bufio.NewScanner(r)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		kv := []string{}
+		if len(parts) == 2 {
+			kv = strings.SplitN(strings.TrimSpace(parts[1]), "=", 2)
+		}
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("%s:%d: want verb key=value, got %q", name, lineno, line)
+		}
+		switch parts[0] {
+		case "packagefile":
+			cfg.packageFiles[kv[0]] = kv[1]
+		case "importmap":
+			cfg.importMap[kv[0]] = kv[1]
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown directive %q", name, lineno, parts[0])
+		}
+	}
+	return cfg, s.Err()
+}
+
+// importer returns an importer reading the export data listed in cfg,
+// which must include the standard library, as go_genrule's does.
+func (cfg *importCfg) importer(fset *token.FileSet) types.Importer {
+	lookup := func(path string) (io.ReadCloser, error) {
+		if file, ok := cfg.packageFiles[path]; ok {
+			return os.Open(file)
+		}
+		return nil, fmt.Errorf("package %q is not in %s", path, cfg.name)
+	}
+	return &mappedImporter{
+		importer:  importer.ForCompiler(fset, "gc", lookup),
+		importMap: cfg.importMap,
+	}
+}
+
+// mappedImporter applies an importcfg's importmap to import paths.
+type mappedImporter struct {
+	importer  types.Importer
+	importMap map[string]string
+}
+
+func (m *mappedImporter) Import(path string) (*types.Package, error) {
+	if mapped, ok := m.importMap[path]; ok {
+		path = mapped
+	}
+	return m.importer.Import(path)
+}
+
+type multiString []string
+
+func (ms *multiString) String() string {
+	return strings.Join(*ms, ",")
+}
+
+func (ms *multiString) Set(v string) error {
+	*ms = append(*ms, v)
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImportcfg(t *testing.T) {
+	cfg, err := parseImportcfg(strings.NewReader(`# import config
+packagefile fmt=/sdk/fmt.a
+packagefile example.com/vendor/x=/out/x.a
+
+importmap x=example.com/vendor/x
+`), "importcfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"fmt": "/sdk/fmt.a", "example.com/vendor/x": "/out/x.a"}; !reflect.DeepEqual(cfg.packageFiles, want) {
+		t.Errorf("packageFiles = %v; want %v", cfg.packageFiles, want)
+	}
+	if want := map[string]string{"x": "example.com/vendor/x"}; !reflect.DeepEqual(cfg.importMap, want) {
+		t.Errorf("importMap = %v; want %v", cfg.importMap, want)
+	}
+
+	for _, bad := range []string{"packagefile fmt", "packagefile =x", "modinfo x=y"} {
+		if _, err := parseImportcfg(strings.NewReader(bad), "importcfg"); err == nil {
+			t.Errorf("parseImportcfg(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestCheckWithImportcfg(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	dir, err := ioutil.TempDir("", "testgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Export data for go/types and its dependencies, standing in for go_deps
+	// and the standard library listed by go_genrule.
+	list, err := exec.Command("go", "list", "-export", "-deps", "-f", "{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}", "go/types").Output()
+	if err != nil {
+		t.Skipf("couldn't list export data: %v", err)
+	}
+	importcfg := filepath.Join(dir, "importcfg")
+	if err := ioutil.WriteFile(importcfg, list, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for name, src := range map[string]string{
+		"a.go": "package p\n\nimport \"go/types\"\n\nvar A types.Object = b()\n",
+		"b.go": "package p\n\nimport \"go/types\"\n\nfunc b() *types.Var { return nil }\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	fset := token.NewFileSet()
+	files, err := parseFiles(fset, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readImportcfg(importcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: cfg.importer(fset)}
+	if _, err := conf.Check("example.com/p", fset, files, nil); err != nil {
+		t.Errorf("Check() = %v", err)
+	}
+
+	delete(cfg.packageFiles, "go/types")
+	conf = types.Config{Importer: cfg.importer(fset)}
+	if _, err := conf.Check("example.com/p", fset, files, nil); err == nil || !strings.Contains(err.Error(), "is not in") {
+		t.Errorf("Check() without go/types = %v; want error", err)
+	}
+}
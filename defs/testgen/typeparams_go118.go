@@ -0,0 +1,52 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/types"
+	"strings"
+)
+
+// typeParamList formats the type parameters of a generic function or named
+// type t with their constraints, e.g. "[K comparable, V any]", or just their
+// names, e.g. "[K, V]", as in the receiver of a method. It returns "" for
+// other types.
+func typeParamList(t types.Type, typeString func(types.Type) string, constraints bool) string {
+	var tparams *types.TypeParamList
+	switch t := t.(type) {
+	case *types.Signature:
+		tparams = t.TypeParams()
+	case *types.Named:
+		tparams = t.TypeParams()
+	}
+	if tparams.Len() == 0 {
+		return ""
+	}
+	var params []string
+	for i := 0; i < tparams.Len(); i++ {
+		p := tparams.At(i)
+		if constraints {
+			params = append(params, p.Obj().Name()+" "+typeString(p.Constraint()))
+		} else {
+			params = append(params, p.Obj().Name())
+		}
+	}
+	return "[" + strings.Join(params, ", ") + "]"
+}
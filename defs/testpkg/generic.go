@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testpkg
+
+// Map is a generic func.
+func Map[T comparable](x T) T {
+	return x
+}
+
+// List is a generic type.
+type List[T any] struct {
+	Head *T
+}
+
+// Push is a method of a generic type.
+func (l *List[T]) Push(v T) {
+	l.Head = &v
+}
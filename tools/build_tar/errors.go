@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+
+	"k8s.io/repo-infra/tools/build_tar/tarbuilder"
+)
+
+// Exit codes. Differing archives and failed verifications exit with 1.
+const (
+	// exitUsage is for invalid flags or arguments.
+	exitUsage = 2
+	// exitInput is for inputs that can't be read or added.
+	exitInput = 3
+	// exitIO is for failures writing the outputs.
+	exitIO = 4
+)
+
+var errorKinds = map[int]string{
+	exitUsage: "usage",
+	exitInput: "input",
+	exitIO:    "io",
+}
+
+// cliError is a problem reported by build_tar, with the flag and value it
+// concerns, if any.
+type cliError struct {
+	code  int
+	flag  string
+	value string
+	err   error
+}
+
+func (e *cliError) Error() string {
+	switch {
+	case e.flag == "":
+		return e.err.Error()
+	case e.value == "":
+		return fmt.Sprintf("--%s: %v", e.flag, e.err)
+	}
+	return fmt.Sprintf("--%s=%s: %v", e.flag, e.value, e.err)
+}
+
+// cliErrors collects the problems found before exiting.
+type cliErrors []*cliError
+
+// add records err, which concerns the given flag and value if flag is set.
+// The FlagErrors of tarbuilder are recorded one by one.
+func (errs *cliErrors) add(code int, flag, value string, err error) {
+	var flagErrs tarbuilder.FlagErrors
+	if errors.As(err, &flagErrs) {
+		for _, fe := range flagErrs {
+			*errs = append(*errs, &cliError{code: code, flag: fe.Flag, value: fe.Value, err: fe.Err})
+		}
+		return
+	}
+	*errs = append(*errs, &cliError{code: code, flag: flag, value: value, err: err})
+}
+
+// exit reports the errors, in text or json format, and exits with the
+// code of the first one.
+func (errs cliErrors) exit(format string) {
+	code := errs[0].code
+	if format == "json" {
+		type jsonError struct {
+			Kind    string `json:"kind"`
+			Flag    string `json:"flag,omitempty"`
+			Value   string `json:"value,omitempty"`
+			Message string `json:"message"`
+		}
+		report := struct {
+			ExitCode int         `json:"exit_code"`
+			Errors   []jsonError `json:"errors"`
+		}{ExitCode: code}
+		for _, e := range errs {
+			report.Errors = append(report.Errors, jsonError{
+				Kind:    errorKinds[e.code],
+				Flag:    e.flag,
+				Value:   e.value,
+				Message: e.err.Error(),
+			})
+		}
+		klog.Flush()
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, e := range errs {
+			klog.Errorf("%s error: %v", errorKinds[e.code], e)
+		}
+		klog.Flush()
+	}
+	os.Exit(code)
+}
+
+// fail reports a single error and exits.
+func fail(format string, code int, flag, value string, err error) {
+	var errs cliErrors
+	errs.add(code, flag, value, err)
+	errs.exit(format)
+}
@@ -0,0 +1,398 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"k8s.io/repo-infra/tools/build_tar/tarbuilder"
+)
+
+// flags are the values of build_tar's flags, as given.
+type flags struct {
+	flagfile    string
+	errorFormat string
+
+	outputs     multiString
+	directory   string
+	compression string
+	digest      string
+
+	gzipLevel     int
+	gzipBlockSize int
+	gzipParallel  int
+
+	inputArchive  string
+	maxVolumeSize int64
+
+	files       multiString
+	stampFiles  multiString
+	statusFiles multiString
+	tars        multiString
+	squash      bool
+	debs        multiString
+	links       multiString
+
+	mode  string
+	modes multiString
+
+	owner      string
+	owners     multiString
+	ownerName  string
+	ownerNames multiString
+
+	overrideMerged bool
+
+	mtime         string
+	preserveMtime bool
+
+	jobs int
+
+	strictPaths   bool
+	legacyLinks   bool
+	checkLinks    string
+	checkPortable string
+
+	sbomOut      string
+	sbomPackages string
+
+	signKey string
+
+	statsOut string
+	statsTop int
+
+	imageFormat string
+	imageConfig string
+	layers      multiString
+	tags        multiString
+}
+
+// link is a --link symlink.
+type link struct{ symlink, target string }
+
+// config is the archive build_tar writes, parsed from its flags.
+type config struct {
+	errorFormat string
+
+	// outputSpecs are the --output values, for error messages.
+	outputSpecs []string
+	outputs     []tarbuilder.Output
+	opts        tarbuilder.Options
+
+	inputArchive string
+	inputs       []tarbuilder.FileInput
+	tars         []string
+	squash       bool
+	debs         []string
+	image        tarbuilder.Image
+	symlinks     []link
+
+	sbomOut      string
+	sbomPackages string
+	statsOut     string
+	statsTop     int
+}
+
+// parseFlags parses the command line args, and any --flagfile, into the
+// config. It validates every flag before anything is written, returning
+// all problems found together. It exits after printing the usage for
+// -help, and on errors that stop it from parsing the rest.
+func parseFlags(args []string) (config, cliErrors) {
+	var f flags
+	flag.StringVar(&f.flagfile, "flagfile", "", "Path to flagfile")
+
+	flag.Var(&f.outputs, "output", "The output file, mandatory. May be repeated as path:compression (e.g. out.tar.gz:gz) to write several encodings of the same archive, each with a path.sha256 digest file. Only uncompressed (path:) and gz outputs are supported; bz2 and xz are rejected.")
+	flag.StringVar(&f.digest, "digest", "", "Comma-separated digests, e.g. sha256,sha512, to write next to every output as output.<digest>. Overrides the sha256 default of path:compression outputs.")
+	flag.StringVar(&f.directory, "directory", "", "Directory in which to store the file inside the layer")
+	flag.StringVar(&f.compression, "compression", "", "Compression of plain --output paths: `gz`, or none by default. bz2 and xz are not supported.")
+	flag.IntVar(&f.gzipLevel, "gzip_level", -1, "gzip compression level, from 1 (fastest) to 9 (smallest), or -1 for the default. Level 0 (no compression) is not supported; leave out compression instead.")
+	flag.IntVar(&f.gzipBlockSize, "gzip_block_size", tarbuilder.DefaultGzipBlockSize, "Number of uncompressed bytes compressed as one gzip member. Changing it changes the output.")
+	flag.IntVar(&f.gzipParallel, "gzip_parallel", runtime.NumCPU(), "Number of gzip members compressed at once. Output is identical for any value.")
+
+	flag.Int64Var(&f.maxVolumeSize, "max-volume-size", 0, "Split each output into standalone volumes name.000.tar, name.001.tar, ... of at most about this many bytes before compression, with an index of their entries in name.tar.index.json.")
+	flag.StringVar(&f.inputArchive, "input-archive", "", "An existing tar to update: its entries are kept in order, with --file and --stamp-file entries replacing those at the same path (the last one wins) and the rest appended. It may also be the --output.")
+	flag.Var(&f.files, "file", "A file to add to the layer")
+	flag.Var(&f.stampFiles, "stamp-file", "A file to add to the layer as src=dest, replacing {KEY} placeholders with values from --status-file")
+	flag.Var(&f.statusFiles, "status-file", "A Bazel workspace status file, e.g. stable-status.txt, with values for --stamp-file. Later files take precedence.")
+	flag.Var(&f.tars, "tar", "A tar file to add to the layer")
+	flag.BoolVar(&f.squash, "squash", false, "Stack the --tar inputs as image layers: later tars override earlier ones and .wh. whiteout files delete entries.")
+	flag.Var(&f.debs, "deb", "A debian package to add to the layer")
+	flag.Var(&f.links, "link", "Add a symlink a inside the layer ponting to b if a:b is specified")
+
+	flag.StringVar(&f.mode, "mode", "", "Force the mode on the added files (in octal).")
+	flag.Var(&f.modes, "modes", "Specific mode to apply to specific file (from the file argument), e.g., path/to/file=0455.")
+
+	flag.StringVar(&f.owner, "owner", "0.0", "Specify the numeric default owner of all files, e.g., 0.0")
+	flag.Var(&f.owners, "owners", "Specify the numeric owners of individual files, e.g. path/to/file=0.0.")
+	flag.StringVar(&f.ownerName, "owner_name", "", "Specify the owner name of all files, e.g. root.root.")
+	flag.Var(&f.ownerNames, "owner_names", "Specify the owner names of individual files, e.g. path/to/file=root.root.")
+	flag.BoolVar(&f.overrideMerged, "override_merged", false, "Apply --mode, --owner and --owner_name to entries merged with --tar too. Per-path --modes, --owners and --owner_names always apply to them.")
+
+	flag.StringVar(&f.mtime, "mtime", "",
+		"mtime to set on tar file entries. May be an integer (corresponding to epoch seconds) or the value \"portable\", which will use the value 2000-01-01, usable with non *nix OSes. Defaults to $SOURCE_DATE_EPOCH if set, else the epoch.")
+	flag.BoolVar(&f.preserveMtime, "preserve-mtime", false, "Use each file's own mtime instead of --mtime, clamped to $SOURCE_DATE_EPOCH if set.")
+
+	flag.IntVar(&f.jobs, "jobs", runtime.NumCPU(), "Number of inputs to read and hash ahead of the writer. Output is identical for any value.")
+
+	flag.BoolVar(&f.legacyLinks, "legacy_links", false, "Write --link symlinks at the archive root owned by 0:0, ignoring --directory and the owner flags.")
+	flag.BoolVar(&f.strictPaths, "strict_paths", false, "Fail on entries that escape the archive root or traverse a symlink, instead of skipping them.")
+
+	flag.StringVar(&f.checkPortable, "portable-check", "off", "Report entries that collide on case-insensitive or Unicode-normalizing filesystems, use names reserved on Windows, have paths over 255 characters or aren't valid UTF-8: `off`, warn or error.")
+	flag.StringVar(&f.checkLinks, "check_links", "off", "Validate symlinks and hardlinks against the archive contents when done: `off`, warn or error.")
+
+	flag.StringVar(&f.sbomOut, "sbom-out", "", "Write an SPDX 2.3 JSON software bill of materials for the archive to this file.")
+	flag.StringVar(&f.sbomPackages, "sbom-packages", "", "JSON list of {\"name\", \"version\", \"files\"} packages installed in the archive, for --sbom-out.")
+
+	flag.StringVar(&f.signKey, "sign-key", "", "ed25519 or ECDSA private key PEM file. Each output is signed in output.sig, with the key fingerprint in output.fingerprint.")
+
+	flag.StringVar(&f.statsOut, "stats-out", "", "Write JSON statistics of the archive to this file: entries by type, sizes, largest files and bytes per input.")
+	flag.IntVar(&f.statsTop, "stats-top", 20, "Number of largest files listed by --stats-out.")
+
+	flag.StringVar(&f.imageFormat, "image_format", "", "Wrap --layer tarballs into an image: `docker` for docker load, or oci for an OCI image layout.")
+	flag.StringVar(&f.imageConfig, "image_config", "", "The image config JSON, for --image_format.")
+	flag.Var(&f.layers, "layer", "An image layer tarball, from lowest to highest, for --image_format.")
+	flag.Var(&f.tags, "tag", "An image reference to tag the image with, e.g. registry/repo:tag, for --image_format.")
+
+	flag.StringVar(&f.errorFormat, "error-format", "text", "Report errors as `text` or as a json object on stderr. Exit codes: 2 for bad usage, 3 for bad inputs, 4 for failures writing outputs.")
+
+	flag.Set("logtostderr", "true")
+
+	// Parse errors are reported like other errors rather than by the flag
+	// package.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			flag.CommandLine.SetOutput(os.Stderr)
+			flag.Usage()
+			os.Exit(0)
+		}
+		fail(f.errorFormat, exitUsage, "", "", err)
+	}
+
+	if f.flagfile != "" {
+		b, err := ioutil.ReadFile(f.flagfile)
+		if err != nil {
+			fail(f.errorFormat, exitInput, "flagfile", f.flagfile, err)
+		}
+		cmdline := strings.Split(string(b), "\n")
+		if err := flag.CommandLine.Parse(cmdline); err != nil {
+			fail(f.errorFormat, exitUsage, "flagfile", f.flagfile, err)
+		}
+	}
+
+	var errs cliErrors
+	cfg := config{
+		errorFormat:  f.errorFormat,
+		outputSpecs:  f.outputs,
+		inputArchive: f.inputArchive,
+		tars:         f.tars,
+		squash:       f.squash,
+		debs:         f.debs,
+		sbomOut:      f.sbomOut,
+		sbomPackages: f.sbomPackages,
+		statsOut:     f.statsOut,
+		statsTop:     f.statsTop,
+	}
+	if f.errorFormat != "text" && f.errorFormat != "json" {
+		errs.add(exitUsage, "error-format", f.errorFormat, errors.New("want text or json"))
+		cfg.errorFormat = "text"
+	}
+	cfg.outputs = f.parseOutputs(&errs)
+	cfg.opts = f.parseOptions(&errs)
+	cfg.inputs, cfg.symlinks = f.parseInputs(&errs)
+	cfg.image = f.parseImage(&errs)
+	f.checkInputs(cfg.inputs, &errs)
+	return cfg, errs
+}
+
+// parseOutputs parses --output, --compression and --digest.
+func (f *flags) parseOutputs(errs *cliErrors) []tarbuilder.Output {
+	if len(f.outputs) == 0 {
+		errs.add(exitUsage, "output", "", errors.New("flag is required"))
+	}
+	digests, err := tarbuilder.ParseDigests(f.digest)
+	if err != nil {
+		errs.add(exitUsage, "digest", f.digest, err)
+	}
+	if err := tarbuilder.CheckCompression(f.compression); err != nil {
+		errs.add(exitUsage, "compression", f.compression, err)
+	}
+	var outs []tarbuilder.Output
+	for _, output := range f.outputs {
+		out, err := tarbuilder.ParseOutput(output, f.compression)
+		if err != nil {
+			errs.add(exitUsage, "output", output, err)
+			continue
+		}
+		if f.digest != "" {
+			out.Digests = digests
+		}
+		outs = append(outs, out)
+	}
+	return outs
+}
+
+// parseOptions parses the flags setting how the archive is written.
+func (f *flags) parseOptions(errs *cliErrors) tarbuilder.Options {
+	parsedMtime, err := tarbuilder.ParseMtime(f.mtime)
+	if err != nil {
+		errs.add(exitUsage, "mtime", f.mtime, errors.New("want a number of seconds since the epoch or portable"))
+	}
+	sourceDateEpoch, haveSourceDateEpoch, err := tarbuilder.SourceDateEpoch()
+	if err != nil {
+		errs.add(exitUsage, "", "", err)
+	}
+	if f.mtime == "" && haveSourceDateEpoch {
+		parsedMtime = sourceDateEpoch
+	}
+
+	meta, err := tarbuilder.ParseMetadata(f.mode, f.modes, f.owner, f.owners, f.ownerName, f.ownerNames, parsedMtime)
+	if err != nil {
+		errs.add(exitUsage, "", "", err)
+	}
+	meta.PreserveMtime = f.preserveMtime
+	meta.OverrideMerged = f.overrideMerged
+	if haveSourceDateEpoch {
+		meta.ClampTime = sourceDateEpoch
+	}
+
+	linkCheck, err := tarbuilder.ParseLinkCheck(f.checkLinks)
+	if err != nil {
+		errs.add(exitUsage, "check_links", f.checkLinks, err)
+	}
+	portableCheck, err := tarbuilder.ParsePortableCheck(f.checkPortable)
+	if err != nil {
+		errs.add(exitUsage, "portable-check", f.checkPortable, err)
+	}
+	if f.gzipLevel != -1 && (f.gzipLevel < 1 || f.gzipLevel > 9) {
+		errs.add(exitUsage, "gzip_level", fmt.Sprint(f.gzipLevel), errors.New("want 1 to 9, or -1 for the default"))
+	}
+	if f.statsTop < 0 {
+		errs.add(exitUsage, "stats-top", fmt.Sprint(f.statsTop), errors.New("must not be negative"))
+	}
+	if f.maxVolumeSize < 0 {
+		errs.add(exitUsage, "max-volume-size", fmt.Sprint(f.maxVolumeSize), errors.New("must not be negative"))
+	}
+
+	stampValues, err := tarbuilder.StampValues(f.statusFiles)
+	if err != nil {
+		errs.add(exitInput, "status-file", strings.Join(f.statusFiles, ","), err)
+	}
+	var signer crypto.Signer
+	if f.signKey != "" {
+		if signer, err = tarbuilder.LoadSigningKey(f.signKey); err != nil {
+			errs.add(exitInput, "sign-key", f.signKey, err)
+		}
+	}
+
+	return tarbuilder.Options{
+		Directory: f.directory,
+		Gzip: tarbuilder.GzipOptions{
+			Level:     f.gzipLevel,
+			BlockSize: f.gzipBlockSize,
+			Parallel:  f.gzipParallel,
+		},
+		Meta:          meta,
+		StampValues:   stampValues,
+		Jobs:          f.jobs,
+		StrictPaths:   f.strictPaths,
+		LegacyLinks:   f.legacyLinks,
+		LinkCheck:     linkCheck,
+		PortableCheck: portableCheck,
+		MaxVolumeSize: f.maxVolumeSize,
+		Signer:        signer,
+	}
+}
+
+// parseInputs parses --file, --stamp-file and --link.
+func (f *flags) parseInputs(errs *cliErrors) ([]tarbuilder.FileInput, []link) {
+	var inputs []tarbuilder.FileInput
+	for _, file := range f.files {
+		parts := strings.SplitN(file, "=", 2)
+		if len(parts) != 2 {
+			errs.add(exitUsage, "file", file, errors.New("want src=dest"))
+			continue
+		}
+		inputs = append(inputs, tarbuilder.FileInput{Src: parts[0], Dest: parts[1]})
+	}
+	for _, file := range f.stampFiles {
+		parts := strings.SplitN(file, "=", 2)
+		if len(parts) != 2 {
+			errs.add(exitUsage, "stamp-file", file, errors.New("want src=dest"))
+			continue
+		}
+		inputs = append(inputs, tarbuilder.FileInput{Src: parts[0], Dest: parts[1], Stamp: true})
+	}
+	var symlinks []link
+	for _, l := range f.links {
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 {
+			errs.add(exitUsage, "link", l, errors.New("want symlink:target"))
+			continue
+		}
+		symlinks = append(symlinks, link{parts[0], parts[1]})
+	}
+	return inputs, symlinks
+}
+
+// parseImage parses --image_format and the flags describing the image.
+func (f *flags) parseImage(errs *cliErrors) tarbuilder.Image {
+	switch {
+	case f.imageFormat == "":
+	case f.imageFormat != tarbuilder.ImageFormatDocker && f.imageFormat != tarbuilder.ImageFormatOCI:
+		errs.add(exitUsage, "image_format", f.imageFormat, errors.New("want docker or oci"))
+	case f.imageConfig == "":
+		errs.add(exitUsage, "image_config", "", errors.New("flag is required with --image_format"))
+	}
+	return tarbuilder.Image{
+		Format: f.imageFormat,
+		Config: f.imageConfig,
+		Layers: f.layers,
+		Tags:   f.tags,
+	}
+}
+
+// checkInputs reports every missing input, rather than the first one read.
+func (f *flags) checkInputs(inputs []tarbuilder.FileInput, errs *cliErrors) {
+	for _, in := range inputs {
+		if _, err := os.Stat(in.Src); err != nil {
+			flagName := "file"
+			if in.Stamp {
+				flagName = "stamp-file"
+			}
+			errs.add(exitInput, flagName, in.Src+"="+in.Dest, err)
+		}
+	}
+	for _, fl := range []struct {
+		name  string
+		paths []string
+	}{{"tar", f.tars}, {"deb", f.debs}, {"layer", f.layers}} {
+		for _, path := range fl.paths {
+			if _, err := os.Stat(path); err != nil {
+				errs.add(exitInput, fl.name, path, err)
+			}
+		}
+	}
+}
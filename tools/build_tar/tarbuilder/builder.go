@@ -0,0 +1,913 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tarbuilder builds deterministic tar archives from files, other
+// tars and symlinks. It backs the build_tar tool used by pkg_tar.
+package tarbuilder
+
+import (
+	"archive/tar"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// Options configures a Builder.
+type Options struct {
+	// Directory is the directory inside the archive in which files are
+	// stored.
+	Directory string
+	// Compression is "" for none or "gz". It applies to New and Create;
+	// CreateAll takes the compression of each output instead.
+	Compression string
+	// Gzip tunes "gz" compression.
+	Gzip GzipOptions
+	// Signer, if set, signs each output of CreateAll. See LoadSigningKey.
+	Signer crypto.Signer
+	// Meta sets the mode, owner and mtime of added entries.
+	Meta Metadata
+	// StampValues are the workspace status values substituted into
+	// FileInputs with Stamp set. See StampValues.
+	StampValues map[string]string
+	// Jobs is the number of inputs read and hashed ahead of the writer by
+	// AddFiles and AddTars. Values below 1 are treated as 1.
+	Jobs int
+	// StrictPaths makes entries that escape the archive root or traverse an
+	// earlier symlink an error. By default they are skipped with a warning.
+	StrictPaths bool
+	// LinkCheck selects whether Close validates symlinks and hardlinks
+	// against the entries written.
+	LinkCheck LinkCheck
+	// PortableCheck selects whether Close reports names that collide on
+	// case-insensitive or Unicode-normalizing filesystems or can't be
+	// extracted on Windows.
+	PortableCheck PortableCheck
+	// MaxVolumeSize, if positive, makes CreateAll split each output into
+	// volumes of at most about this many bytes of uncompressed tar stream,
+	// at entry boundaries. Each volume is a standalone tar.
+	MaxVolumeSize int64
+	// LegacyLinks writes AddLink symlinks as given, at the archive root and
+	// owned by 0:0, rather than under Directory with the owners of Meta.
+	LegacyLinks bool
+}
+
+// Builder writes a tar archive. Paths are written at most once; later
+// duplicates are dropped with a warning.
+type Builder struct {
+	directory   string
+	jobs        int
+	strictPaths bool
+	legacyLinks bool
+	stampValues map[string]string
+
+	tw *tar.Writer
+	// out is the writer under tw, for entries tw can't encode.
+	out *countingWriter
+	// volumes, if not nil, splits the archive into volumes.
+	volumes *volumeSet
+
+	meta      Metadata
+	dirsMade  map[string]struct{}
+	filesMade map[string]struct{}
+	// written maps the cleaned path of every entry written so far to its
+	// type, and symlinks maps the cleaned path of each symlink to its target.
+	written  map[string]byte
+	symlinks map[string]string
+	// hardlinks maps the cleaned path of each hardlink to its target, and
+	// forwardLinks holds the hardlinks written before their target.
+	hardlinks    map[string]string
+	forwardLinks map[string]bool
+	linkCheck    LinkCheck
+	// portableNames maps the folded path (see foldName) of every entry
+	// written to the first path folded to it, and portableProblems lists the
+	// problems found so far, if PortableCheck is enabled.
+	portableCheck    PortableCheck
+	portableNames    map[string]string
+	portableProblems []string
+	// contents lists the regular files written, in order.
+	contents []writtenFile
+	stats    buildStats
+
+	closers []func() error
+	// tmpFiles are the outputs being written by CreateAll, removed by
+	// Abort.
+	tmpFiles []*os.File
+}
+
+// Create creates the file at output and returns a Builder writing to it,
+// compressed as opts.Compression.
+func Create(output string, opts Options) (*Builder, error) {
+	return CreateAll([]Output{{Path: output, Compression: opts.Compression}}, opts)
+}
+
+// New returns a Builder writing to w. Close must be called to flush the
+// archive, but it does not close w.
+func New(w io.Writer, opts Options) (*Builder, error) {
+	w, closers, err := newCompressedWriter(w, opts.Compression, opts.Gzip)
+	if err != nil {
+		return nil, err
+	}
+	return newBuilder(w, closers, opts), nil
+}
+
+// newBuilder returns a Builder writing the archive to w. closers are run in
+// reverse order after the tar writer is closed.
+func newBuilder(w io.Writer, closers []func() error, opts Options) *Builder {
+	counter := &countingWriter{w: outputWriter{w}}
+	tw := tar.NewWriter(counter)
+	closers = append(closers, tw.Close)
+
+	return &Builder{
+		directory:     opts.Directory,
+		jobs:          opts.Jobs,
+		strictPaths:   opts.StrictPaths,
+		legacyLinks:   opts.LegacyLinks,
+		stampValues:   opts.StampValues,
+		tw:            tw,
+		out:           counter,
+		closers:       closers,
+		meta:          opts.Meta,
+		dirsMade:      map[string]struct{}{},
+		filesMade:     map[string]struct{}{},
+		written:       map[string]byte{},
+		symlinks:      map[string]string{},
+		hardlinks:     map[string]string{},
+		forwardLinks:  map[string]bool{},
+		linkCheck:     opts.LinkCheck,
+		portableCheck: opts.PortableCheck,
+		portableNames: map[string]string{},
+		stats:         newBuildStats(counter),
+	}
+}
+
+// AddFile adds the file or directory at src to the archive as dest.
+func (b *Builder) AddFile(src, dest string) error {
+	b.stats.setSource("file", "")
+	return b.addPrefetchedFile(readFileInput(FileInput{Src: src, Dest: dest}, nil, newPrefetchBudget(filePrefetchBytes)))
+}
+
+// AddFiles adds each of the inputs as if by AddFile, reading and hashing
+// upcoming inputs in parallel. The output is the same as calling AddFile
+// in order.
+func (b *Builder) AddFiles(inputs []FileInput) error {
+	b.stats.setSource("file", "")
+	done := make(chan struct{})
+	defer close(done)
+	for ch := range prefetchFiles(inputs, b.stampValues, b.jobs, done) {
+		pf := <-ch
+		err := b.addPrefetchedFile(pf)
+		pf.finish()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) addPrefetchedFile(pf *prefetchedFile) error {
+	dest, err := cleanArchivePath(pf.Dest)
+	if err != nil {
+		return b.rejectUnsafe(err)
+	}
+
+	uid := b.meta.getUID(dest)
+	gid := b.meta.getGID(dest)
+	uname := b.meta.getUname(dest)
+	gname := b.meta.getGname(dest)
+
+	dest = filepath.Join(strings.TrimLeft(b.directory, "/"), dest)
+	dest = filepath.Clean(dest)
+
+	if err := b.checkNoSymlinkParent(dest); err != nil {
+		return b.rejectUnsafe(err)
+	}
+	if ok := b.tryReservePath(dest); !ok {
+		klog.Warningf("Duplicate file in archive: %v, picking first occurence", dest)
+		return nil
+	}
+
+	if pf.err != nil {
+		return &InputError{Path: pf.Src, Err: pf.err}
+	}
+	info := pf.info
+
+	mode := b.meta.getMode(dest)
+	// If mode is unspecified, derive the mode from the file's mode.
+	if mode == 0 {
+		mode = os.FileMode(0644)
+		if info.Mode().Perm()&os.FileMode(0111) != 0 {
+			mode = os.FileMode(0755)
+		}
+	}
+
+	header := tar.Header{
+		Name:    dest,
+		Mode:    int64(mode),
+		Uid:     uid,
+		Gid:     gid,
+		Size:    0,
+		Uname:   uname,
+		Gname:   gname,
+		ModTime: b.meta.modTime(info.ModTime()),
+	}
+
+	stored := int64(len(pf.data))
+	if pf.sparse != nil {
+		stored = pf.sparse.storedSize(pf.data)
+	} else if pf.stream {
+		stored = info.Size()
+	}
+	if err := b.makeDirs(header, stored); err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return fmt.Errorf("addFile: didn't expect symlink: %s", pf.Src)
+	case info.Mode()&os.ModeNamedPipe != 0:
+		return fmt.Errorf("addFile: didn't expect named pipe: %s", pf.Src)
+	case info.Mode()&os.ModeSocket != 0:
+		return fmt.Errorf("addFile: didn't expect socket: %s", pf.Src)
+	case info.Mode()&os.ModeDevice != 0:
+		return fmt.Errorf("addFile: didn't expect device: %s", pf.Src)
+	case info.Mode()&os.ModeDir != 0:
+		header.Typeflag = tar.TypeDir
+		if err := b.writeHeader(&header); err != nil {
+			return err
+		}
+	default:
+		//regular file
+		header.Typeflag = tar.TypeReg
+		if pf.stream {
+			header.Size = info.Size()
+			if err := b.writeFile(&header, pf.Src); err != nil {
+				return err
+			}
+			klog.V(2).Infof("Added %s as %s", pf.Src, dest)
+			return nil
+		}
+		if pf.sparse != nil {
+			header.Size = pf.sparse.size
+			if err := b.writeSparse(&header, pf.sparse, pf.data, pf.sha256); err != nil {
+				return err
+			}
+		} else {
+			header.Size = int64(len(pf.data))
+			if err := b.writeEntry(&header, pf.data, pf.sha256); err != nil {
+				return err
+			}
+		}
+		klog.V(2).Infof("Added %s as %s (sha256 %x)", pf.Src, dest, pf.sha256)
+	}
+	return nil
+}
+
+// AddDir adds an empty directory to the archive as dest. Its mode
+// defaults to 0755.
+func (b *Builder) AddDir(dest string) error {
+	b.stats.setSource("dir", "")
+	dest, err := cleanArchivePath(dest)
+	if err != nil {
+		return b.rejectUnsafe(err)
+	}
+
+	mode := b.meta.getMode(dest)
+	if mode == 0 {
+		mode = os.FileMode(0755)
+	}
+	header := tar.Header{
+		Name:     dest,
+		Typeflag: tar.TypeDir,
+		Mode:     int64(mode),
+		Uid:      b.meta.getUID(dest),
+		Gid:      b.meta.getGID(dest),
+		Uname:    b.meta.getUname(dest),
+		Gname:    b.meta.getGname(dest),
+		ModTime:  b.meta.ModTime,
+	}
+
+	dest = filepath.Join(strings.TrimLeft(b.directory, "/"), dest)
+	header.Name = filepath.Clean(dest)
+
+	if err := b.checkNoSymlinkParent(header.Name); err != nil {
+		return b.rejectUnsafe(err)
+	}
+	_, isFile := b.filesMade[header.Name]
+	_, isDir := b.dirsMade[header.Name]
+	if isFile || isDir {
+		klog.Warningf("Duplicate file in archive: %v, picking first occurence", header.Name)
+		return nil
+	}
+	if err := b.makeDirs(header, 0); err != nil {
+		return err
+	}
+	name := header.Name
+	header.Name += "/"
+	if err := b.writeHeader(&header); err != nil {
+		return err
+	}
+	// Record the directory as made so that makeDirs doesn't write it again
+	// for entries below it.
+	b.markDirMade(name, &header)
+	return nil
+}
+
+// AddLink adds a symlink at symlink pointing to target. Like files, the
+// symlink is placed under the archive's directory and takes its owner from
+// the builder's metadata, unless Options.LegacyLinks is set.
+func (b *Builder) AddLink(symlink, target string) error {
+	b.stats.setSource("link", "")
+	if b.legacyLinks {
+		return b.addLegacyLink(symlink, target)
+	}
+	dest, err := cleanArchivePath(symlink)
+	if err != nil {
+		return b.rejectUnsafe(err)
+	}
+
+	header := tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Linkname: target,
+		Mode:     int64(0777), // symlinks should always have 0777 mode
+		Uid:      b.meta.getUID(dest),
+		Gid:      b.meta.getGID(dest),
+		Uname:    b.meta.getUname(dest),
+		Gname:    b.meta.getGname(dest),
+		ModTime:  b.meta.ModTime,
+	}
+
+	dest = filepath.Join(strings.TrimLeft(b.directory, "/"), dest)
+	header.Name = filepath.Clean(dest)
+
+	if err := b.checkNoSymlinkParent(header.Name); err != nil {
+		return b.rejectUnsafe(err)
+	}
+	if ok := b.tryReservePath(header.Name); !ok {
+		klog.Warningf("Duplicate file in archive: %v, picking first occurence", header.Name)
+		return nil
+	}
+	// The missing parents are plain directories with the default owner,
+	// rather than taking the symlink's 0777 mode.
+	dir := tar.Header{
+		Mode:    0755,
+		Uid:     b.meta.DefaultUID,
+		Gid:     b.meta.DefaultGID,
+		Uname:   b.meta.DefaultUname,
+		Gname:   b.meta.DefaultGname,
+		ModTime: b.meta.ModTime,
+	}
+	if err := b.makeDirsFrom(header, 0, dir); err != nil {
+		return err
+	}
+	return b.writeHeader(&header)
+}
+
+// addLegacyLink adds a symlink at the archive root, ignoring the archive's
+// directory and metadata. Its name is checked like any other entry's.
+func (b *Builder) addLegacyLink(symlink, target string) error {
+	name, err := cleanArchivePath(symlink)
+	if err != nil {
+		return b.rejectUnsafe(err)
+	}
+	if err := b.checkNoSymlinkParent(name); err != nil {
+		return b.rejectUnsafe(err)
+	}
+	if ok := b.tryReservePath(name); !ok {
+		klog.Warningf("Duplicate file in archive: %v, picking first occurence", name)
+		return nil
+	}
+	header := tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeSymlink,
+		Linkname: target,
+		Mode:     int64(0777), // symlinks should always have 0777 mode
+		ModTime:  b.meta.ModTime,
+	}
+	if err := b.makeDirs(header, 0); err != nil {
+		return err
+	}
+	return b.writeHeader(&header)
+}
+
+// AddTar adds the entries of the possibly compressed tar at path under the
+// archive's directory.
+func (b *Builder) AddTar(path string) error {
+	done := make(chan struct{})
+	defer close(done)
+	return b.addTarEntries(prefetchTar(path, newPrefetchBudget(tarPrefetchBytes), done), strings.TrimLeft(b.directory, "/"), nil)
+}
+
+// AddTars adds each of the tars as if by AddTar, decoding upcoming tars in
+// parallel. The output is the same as calling AddTar in order.
+func (b *Builder) AddTars(paths []string) error {
+	done := make(chan struct{})
+	defer close(done)
+	for entries := range prefetchTars(paths, b.jobs, done) {
+		if err := b.addTarEntries(entries, strings.TrimLeft(b.directory, "/"), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tarMerge is the state of adding the entries of one input tar.
+type tarMerge struct {
+	root    string
+	replace func(name string) (bool, error)
+	// Hardlinks name their targets by their path in the input tar. written
+	// maps those paths to where the entries ended up in the archive, and
+	// dropped holds the entries dropped as duplicates, so that hardlinks to
+	// them can be written as copies instead.
+	written map[string]string
+	dropped map[string]tarEntry
+	// When the archive is split into volumes, sources maps the paths of
+	// the written entries to the entries holding their contents, so that
+	// hardlinks landing in a later volume than their target can be written
+	// as copies too. Contents other than sparse ones are read again from
+	// the input tar.
+	sources map[string]tarEntry
+}
+
+// addTarEntries writes the decoded entries of an input tar to the archive
+// under root. If replace is not nil, it is called with the final path of
+// each entry other than a directory, and returns whether it wrote a
+// replacement for the entry.
+func (b *Builder) addTarEntries(entries <-chan tarEntry, root string, replace func(name string) (bool, error)) error {
+	m := &tarMerge{
+		root:    root,
+		replace: replace,
+		written: map[string]string{},
+		dropped: map[string]tarEntry{},
+		sources: map[string]tarEntry{},
+	}
+	for entry := range entries {
+		if entry.err != nil {
+			return entry.err
+		}
+		err := b.addTarEntry(m, entry)
+		entry.finish()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTarEntry writes one entry of an input tar.
+func (b *Builder) addTarEntry(m *tarMerge, entry tarEntry) error {
+	b.stats.setSource("tar", entry.tar)
+	header := entry.header
+	inputName, err := cleanArchivePath(header.Name)
+	name := inputName
+	if err == nil {
+		name = filepath.Join(m.root, name)
+		err = b.checkNoSymlinkParent(name)
+	}
+	var (
+		target string
+		copied bool
+		orig   tarEntry
+	)
+	if err == nil && header.Typeflag == tar.TypeLink {
+		// Hardlink targets are archive paths too, even when absolute.
+		target, err = cleanArchivePath(header.Linkname)
+		var ok bool
+		if orig, ok = m.dropped[target]; ok && err == nil {
+			klog.V(2).Infof("Writing hardlink %s as a copy of %s, which was dropped", name, target)
+			copyEntry(header, &entry, orig)
+			copied = true
+		} else if err == nil {
+			if linkname, ok := m.written[target]; ok {
+				header.Linkname = linkname
+			} else {
+				header.Linkname = filepath.Join(m.root, target)
+			}
+			err = b.checkNoSymlinkParent(header.Linkname)
+		}
+	}
+	if err != nil {
+		return b.rejectUnsafe(err)
+	}
+	if m.replace != nil && header.Typeflag != tar.TypeDir {
+		replaced, err := m.replace(name)
+		if err != nil {
+			return err
+		}
+		if replaced {
+			m.written[inputName] = name
+			return nil
+		}
+	}
+	header.Name = name
+	b.meta.clampHeader(header)
+	b.meta.applyToMerged(header)
+	if header.Typeflag == tar.TypeDir && !strings.HasSuffix(header.Name, "/") {
+		header.Name = header.Name + "/"
+	} else if ok := b.tryReservePath(header.Name); !ok {
+		klog.Warningf("Duplicate file in archive: %v, picking first occurence", header.Name)
+		if header.Typeflag == tar.TypeLink {
+			m.written[inputName] = header.Linkname
+		} else if header.Typeflag != tar.TypeDir {
+			// A streamed entry is read again from its tar if a hardlink
+			// needs a copy of it.
+			entry.stream = nil
+			m.dropped[inputName] = entry
+		}
+		return nil
+	}
+	stored := entry.storedSize()
+	var (
+		source    tarEntry
+		hasSource bool
+	)
+	if header.Typeflag == tar.TypeLink {
+		source, hasSource = m.sources[target]
+		if hasSource && b.volumes.inEarlierVolume(header.Linkname) {
+			stored = source.storedSize()
+		}
+	}
+	// Create root directories with same permissions if missing.
+	// makeDirs keeps track of which directories exist,
+	// so it's safe to duplicate this here.
+	if err := b.makeDirs(*header, stored); err != nil {
+		return err
+	}
+	// If this is a directory, then makeDirs already created it,
+	// so skip to the next entry.
+	if header.Typeflag == tar.TypeDir {
+		return nil
+	}
+	if header.Typeflag == tar.TypeLink && hasSource && b.volumes.inEarlierVolume(header.Linkname) {
+		// Each volume extracts on its own, so the link can't point into
+		// an earlier one. If the link started the volume, the copy stays
+		// in it too.
+		klog.V(2).Infof("Writing hardlink %s as a copy of %s, which is in an earlier volume", name, header.Linkname)
+		orig = source
+		copyEntry(header, &entry, orig)
+		copied = true
+	}
+	if err := b.writeTarEntry(header, entry, copied, orig); err != nil {
+		return err
+	}
+	m.written[inputName] = header.Name
+	switch {
+	case b.volumes == nil:
+	case copied:
+		m.sources[inputName] = orig
+	case header.Typeflag == tar.TypeLink:
+		if hasSource {
+			m.sources[inputName] = source
+		}
+	default:
+		entry.stream, entry.finished = nil, nil
+		if entry.sparse == nil {
+			entry.data = nil
+		}
+		m.sources[inputName] = entry
+	}
+	if copied {
+		// Later hardlinks to the same target can link to the copy.
+		m.written[target] = header.Name
+		delete(m.dropped, target)
+	}
+	return nil
+}
+
+// copyEntry makes header and entry, of a hardlink, those of a copy of orig.
+func copyEntry(header *tar.Header, entry *tarEntry, orig tarEntry) {
+	header.Typeflag = orig.header.Typeflag
+	header.Linkname = orig.header.Linkname
+	header.Size = orig.header.Size
+	entry.data, entry.sha256, entry.sparse = orig.data, orig.sha256, orig.sparse
+}
+
+// writeTarEntry writes the contents of an input tar entry under header. If
+// copied, the contents are those of orig, a dropped entry.
+func (b *Builder) writeTarEntry(header *tar.Header, entry tarEntry, copied bool, orig tarEntry) error {
+	switch {
+	case entry.sparse != nil:
+		return b.writeSparse(header, entry.sparse, entry.data, entry.sha256)
+	case copied && orig.data == nil && orig.header.Size > 0:
+		r, closer, err := openTarEntry(orig.tar, orig.index)
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		return b.writeStream(header, r)
+	case entry.stream != nil:
+		return b.writeStream(header, entry.stream)
+	default:
+		return b.writeEntry(header, entry.data, entry.sha256)
+	}
+}
+
+// AddDeb adds the data of a Debian package. It is not implemented yet.
+func (b *Builder) AddDeb(_ string) error {
+	return fmt.Errorf("addDeb unimplemented")
+}
+
+// makeDirs writes the parent directories of header missing from the
+// archive, or from the current volume, making room in the volume for them
+// and the entry itself, with stored bytes of data, first.
+func (b *Builder) makeDirs(header tar.Header, stored int64) error {
+	return b.makeDirsFrom(header, stored, header)
+}
+
+// makeDirsFrom is makeDirs, deriving the missing directories from the
+// header template instead of from the entry.
+func (b *Builder) makeDirsFrom(header tar.Header, stored int64, template tar.Header) error {
+	dirToMake := []string{}
+	dir := header.Name
+	for {
+		dir = filepath.Dir(dir)
+		if dir == "." || dir == "/" {
+			break
+		}
+		dirToMake = append(dirToMake, dir)
+	}
+	var dirs []tar.Header
+	for i := len(dirToMake) - 1; i >= 0; i-- {
+		dir := dirToMake[i]
+		if b.dirMade(dir) {
+			continue
+		}
+		if b.volumes != nil {
+			// A directory recreated in a later volume keeps its header.
+			if dh, ok := b.volumes.dirs[dir]; ok {
+				dirs = append(dirs, dh)
+				continue
+			}
+		}
+		dh := template
+		// Add the x bit to directories if the read bit is set,
+		// and make sure all directories are at least user RWX.
+		dh.Mode = template.Mode | 0700 | ((0444 & template.Mode) >> 2)
+		dh.Typeflag = tar.TypeDir
+		dh.Name = dir + "/"
+		dh.Size = 0
+		dh.Linkname = ""
+		dirs = append(dirs, dh)
+	}
+	if err := b.reserveEntry(&header, stored, dirs); err != nil {
+		return err
+	}
+	for i := range dirs {
+		dir := strings.TrimSuffix(dirs[i].Name, "/")
+		// Starting a volume may have written it already.
+		if b.dirMade(dir) {
+			continue
+		}
+		if err := b.writeHeader(&dirs[i]); err != nil {
+			return err
+		}
+		b.markDirMade(dir, &dirs[i])
+	}
+	return nil
+}
+
+// dirMade returns whether the directory dir was written to the archive,
+// and to the current volume if it is split into volumes.
+func (b *Builder) dirMade(dir string) bool {
+	if _, ok := b.dirsMade[dir]; !ok {
+		return false
+	}
+	if b.volumes != nil {
+		_, ok := b.volumes.made[dir]
+		return ok
+	}
+	return true
+}
+
+// markDirMade records the directory dir as written with header.
+func (b *Builder) markDirMade(dir string, header *tar.Header) {
+	b.dirsMade[dir] = struct{}{}
+	if v := b.volumes; v != nil {
+		v.made[dir] = struct{}{}
+		if _, ok := v.dirs[dir]; !ok {
+			v.dirs[dir] = *header
+		}
+	}
+}
+
+// writeHeader writes header to the archive and records the entry.
+func (b *Builder) writeHeader(header *tar.Header) error {
+	if err := b.reserveVolume(header, header.Size); err != nil {
+		return err
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	b.recordHeader(header)
+	return nil
+}
+
+// recordHeader records an entry written to the archive.
+func (b *Builder) recordHeader(header *tar.Header) {
+	name := filepath.Clean(strings.TrimLeft(header.Name, "/"))
+	b.written[name] = header.Typeflag
+	b.checkPortableName(name)
+	b.stats.addEntry(header.Typeflag)
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		b.symlinks[name] = header.Linkname
+	case tar.TypeLink:
+		b.hardlinks[name] = header.Linkname
+		if target, err := cleanArchivePath(header.Linkname); err == nil {
+			if _, ok := b.written[target]; !ok {
+				b.forwardLinks[name] = true
+			}
+		}
+	}
+	if b.volumes != nil {
+		b.volumes.record(name, header)
+	}
+}
+
+// writeEntry writes header followed by data, whose sha256 digest is sum,
+// and records the digests of regular files.
+func (b *Builder) writeEntry(header *tar.Header, data []byte, sum [sha256.Size]byte) error {
+	if err := b.writeHeader(header); err != nil {
+		return err
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		return err
+	}
+	b.recordData(header, len(data), sum)
+	return nil
+}
+
+// writeStream writes header followed by its Size bytes read from r, and
+// records the digests of regular files.
+func (b *Builder) writeStream(header *tar.Header, r io.Reader) error {
+	if err := b.writeHeader(header); err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(b.tw, io.TeeReader(io.LimitReader(r, header.Size), h))
+	if err != nil {
+		return err
+	}
+	if n != header.Size {
+		return fmt.Errorf("%s: read %d bytes, want %d", header.Name, n, header.Size)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	b.recordData(header, int(n), sum)
+	return nil
+}
+
+// writeFile writes header followed by its Size bytes read from the file at
+// path.
+func (b *Builder) writeFile(header *tar.Header, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return &InputError{Path: path, Err: err}
+	}
+	defer f.Close()
+	return b.writeStream(header, inputReader{r: f, path: path})
+}
+
+// recordData records the n bytes of data written for an entry, whose full
+// contents have the sha256 digest sum.
+func (b *Builder) recordData(header *tar.Header, n int, sum [sha256.Size]byte) {
+	b.stats.addData(n)
+	if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+		b.contents = append(b.contents, writtenFile{
+			name:   filepath.Clean(strings.TrimLeft(header.Name, "/")),
+			size:   header.Size,
+			sha256: sum,
+			source: b.stats.current,
+		})
+	}
+}
+
+func (b *Builder) tryReservePath(path string) bool {
+	if _, ok := b.filesMade[path]; ok {
+		return false
+	}
+	if _, ok := b.dirsMade[path]; ok {
+		return false
+	}
+	b.filesMade[path] = struct{}{}
+	return true
+}
+
+// CheckError is returned by Close when a LinkCheck or PortableCheck set to
+// error finds problems with the entries of the archive.
+type CheckError struct {
+	Err error
+}
+
+func (e *CheckError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
+// InputError is returned for an input file or tar that can't be read.
+type InputError struct {
+	Path string
+	Err  error
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// OutputError is returned when the archive can't be written to its
+// outputs.
+type OutputError struct {
+	Err error
+}
+
+func (e *OutputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// outputWriter returns the errors writing to w as OutputErrors.
+type outputWriter struct {
+	w io.Writer
+}
+
+func (ow outputWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.Write(p)
+	if err != nil {
+		err = &OutputError{Err: err}
+	}
+	return n, err
+}
+
+// Close finishes the archive and flushes it to the outputs. It returns a
+// *CheckError, without finishing the outputs, if the link or portability
+// checks fail, or else the first error finishing them. After an error,
+// Abort removes the incomplete outputs created by CreateAll.
+func (b *Builder) Close() error {
+	checkErr := b.checkLinks()
+	if err := b.checkPortable(); err != nil && checkErr == nil {
+		checkErr = err
+	}
+	if checkErr != nil {
+		return &CheckError{Err: checkErr}
+	}
+	// Later closers rely on the earlier ones: an output is only renamed
+	// into place once it is flushed.
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i](); err != nil {
+			return err
+		}
+	}
+	if b.volumes != nil {
+		if err := b.writeVolumeIndexes(); err != nil {
+			return err
+		}
+		b.volumes.finished = nil
+	}
+	b.closers = nil
+	b.tmpFiles = nil
+	return nil
+}
+
+// Abort stops writing the archive, removing the incomplete outputs created
+// by CreateAll, along with any volumes of them already finished. Outputs
+// written with New are left to the caller.
+func (b *Builder) Abort() {
+	removeTmpFiles(b.tmpFiles)
+	b.tmpFiles = nil
+	b.closers = nil
+	if b.volumes != nil {
+		// The volumes written so far are incomplete without the rest.
+		for _, path := range b.volumes.finished {
+			os.Remove(path)
+		}
+		b.volumes.finished = nil
+	}
+}
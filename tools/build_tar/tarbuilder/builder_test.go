@@ -0,0 +1,314 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTestTar returns a one-line description of each entry in the tar.
+func readTestTar(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var entries []string
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := fmt.Sprintf("%s %c %04o %d:%d", h.Name, h.Typeflag, h.Mode, h.Uid, h.Gid)
+		if h.Linkname != "" {
+			entry += " -> " + h.Linkname
+		}
+		if len(data) > 0 {
+			entry += " " + string(data)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := ParseMetadata("", []string{"/opt/bin/tool=0700"}, "0.0", []string{"bin/tool=1.2", "lib/tool=3.4"}, "", []string{"lib/tool=app.app"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{Directory: "/opt", Meta: meta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(src, "bin/tool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(src, "/bin/tool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddDir("var/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(src, "var/empty/f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("tool", "opt/bin/tool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("/lib/tool", "../bin/tool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("bin/tool", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(filepath.Join(dir, "missing"), "missing"); err == nil {
+		t.Errorf("expected error adding a missing file")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"opt/ 5 0700 1:2",
+		"opt/bin/ 5 0700 1:2",
+		"opt/bin/tool 0 0700 1:2 data",
+		"opt/var/ 5 0755 0:0",
+		"opt/var/empty/ 5 0755 0:0",
+		"opt/var/empty/f 0 0755 0:0 data",
+		"opt/tool 2 0777 0:0 -> opt/bin/tool",
+		"opt/lib/ 5 0755 0:0",
+		"opt/lib/tool 2 0777 3:4 -> ../bin/tool",
+	}
+	if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAddLinkParentDirs(t *testing.T) {
+	meta, err := ParseMetadata("", nil, "5.6", []string{"kubectl=7.8"}, "", nil, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{Directory: "/usr/bin", Meta: meta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("kubectl", "/opt/kubectl"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The parents are 0755 with the default owner, rather than copies of
+	// the symlink.
+	want := []string{
+		"usr/ 5 0755 5:6",
+		"usr/bin/ 5 0755 5:6",
+		"usr/bin/kubectl 2 0777 7:8 -> /opt/kubectl",
+	}
+	if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLegacyLinks(t *testing.T) {
+	meta, err := ParseMetadata("", nil, "1.2", nil, "app.app", nil, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{Directory: "/opt", Meta: meta, LegacyLinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("usr/bin/tool", "/opt/tool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("/usr/lib", "/opt/lib"); err != nil {
+		t.Fatal(err)
+	}
+	// Legacy links are sanitized like other entries.
+	if err := b.AddLink("../escape", "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("usr/lib/x", "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"usr/ 5 0777 0:0",
+		"usr/bin/ 5 0777 0:0",
+		"usr/bin/tool 2 0777 0:0 -> /opt/tool",
+		"usr/lib 2 0777 0:0 -> /opt/lib",
+	}
+	if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAddTarHardlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "bin/alias", Typeflag: tar.TypeLink, Linkname: "bin/tool", Mode: 0755},
+		{Name: "bin/alias2", Typeflag: tar.TypeLink, Linkname: "/bin/tool", Mode: 0755},
+		{Name: "lib/a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "lib/b", Typeflag: tar.TypeLink, Linkname: "lib/a", Mode: 0644},
+		{Name: "lib/c", Typeflag: tar.TypeLink, Linkname: "./lib/b", Mode: 0644},
+	}, map[string]string{"bin/tool": "new", "lib/a": "a"})
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{Directory: "opt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(src, "bin/tool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddTar(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"opt/ 5 0755 0:0",
+		"opt/bin/ 5 0755 0:0",
+		"opt/bin/tool 0 0644 0:0 old",
+		"opt/bin/alias 0 0755 0:0 new",
+		"opt/bin/alias2 1 0755 0:0 -> opt/bin/alias",
+		"opt/lib/ 5 0755 0:0",
+		"opt/lib/a 0 0644 0:0 a",
+		"opt/lib/b 1 0644 0:0 -> opt/lib/a",
+		"opt/lib/c 1 0644 0:0 -> opt/lib/b",
+	}
+	if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAddTarParentDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "a/f", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b/h", Typeflag: tar.TypeLink, Linkname: "a/f", Mode: 0644},
+	}, map[string]string{"a/f": "data"})
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddTar(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Implicit parent directories take the mode and owner of the entry,
+	// but not its size or link target.
+	want := []string{
+		"a/ 5 0755 0:0",
+		"a/f 0 0644 0:0 data",
+		"b/ 5 0755 0:0",
+		"b/h 1 0644 0:0 -> a/f",
+	}
+	if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseMetadataErrors(t *testing.T) {
+	for _, tc := range []struct {
+		mode, owner string
+		modes       []string
+		ownerNames  []string
+	}{
+		{mode: "9"},
+		{owner: "0"},
+		{owner: "a.0"},
+		{modes: []string{"no-equals"}},
+		{modes: []string{"=0644x"}},
+		{ownerNames: []string{"f=root"}},
+	} {
+		if _, err := ParseMetadata(tc.mode, tc.modes, tc.owner, nil, "", tc.ownerNames, time.Unix(0, 0)); err == nil {
+			t.Errorf("ParseMetadata(%+v) succeeded; want error", tc)
+		}
+	}
+}
+
+func TestParseMetadataCollectsErrors(t *testing.T) {
+	_, err := ParseMetadata("9", []string{"a=0644", "b"}, "root", []string{"c=1.x"}, "", nil, time.Unix(0, 0))
+	errs, ok := err.(FlagErrors)
+	if !ok {
+		t.Fatalf("ParseMetadata() = %v; want FlagErrors", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Flag+"="+e.Value)
+	}
+	if want := []string{"mode=9", "modes=b", "owner=root", "owners=c=1.x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors for %v; want %v", got, want)
+	}
+}
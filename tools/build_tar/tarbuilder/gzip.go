@@ -0,0 +1,199 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultGzipBlockSize is the default number of uncompressed bytes per gzip
+// member.
+const DefaultGzipBlockSize = 1 << 20
+
+// GzipOptions tunes gzip compression. The compressed output depends only on
+// the input, Level and BlockSize; it is the same for any Parallel.
+type GzipOptions struct {
+	// Level is a compress/gzip level. Zero means gzip.DefaultCompression,
+	// since uncompressed output is requested with an empty Compression.
+	Level int
+	// BlockSize is the number of uncompressed bytes compressed as one gzip
+	// member. Zero means DefaultGzipBlockSize.
+	BlockSize int
+	// Parallel is the number of blocks compressed at once. Zero means
+	// runtime.NumCPU().
+	Parallel int
+}
+
+func (o GzipOptions) withDefaults() (GzipOptions, error) {
+	if o.Level == 0 {
+		o.Level = gzip.DefaultCompression
+	}
+	if o.Level < gzip.HuffmanOnly || o.Level > gzip.BestCompression {
+		return o, fmt.Errorf("invalid gzip level %d", o.Level)
+	}
+	if o.BlockSize == 0 {
+		o.BlockSize = DefaultGzipBlockSize
+	}
+	if o.BlockSize < 0 {
+		return o, fmt.Errorf("invalid gzip block size %d", o.BlockSize)
+	}
+	if o.Parallel == 0 {
+		o.Parallel = runtime.NumCPU()
+	}
+	if o.Parallel < 0 {
+		return o, fmt.Errorf("invalid gzip parallelism %d", o.Parallel)
+	}
+	return o, nil
+}
+
+// gzipBlock is a block of input being compressed.
+type gzipBlock struct {
+	data []byte
+	done chan struct{}
+	z    bytes.Buffer
+	err  error
+}
+
+// parallelGzipWriter compresses fixed-size blocks of its input as separate
+// gzip members on several goroutines, and writes the members in order.
+// Every member has the same fixed header: no name, zero mtime and an
+// "unknown" OS byte.
+type parallelGzipWriter struct {
+	opts GzipOptions
+	w    io.Writer
+
+	buf     []byte
+	started bool
+	blocks  chan *gzipBlock
+	sem     chan struct{}
+
+	writerDone chan struct{}
+	mu         sync.Mutex
+	err        error
+}
+
+func newParallelGzipWriter(w io.Writer, opts GzipOptions) (*parallelGzipWriter, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	zw := &parallelGzipWriter{
+		opts:       opts,
+		w:          w,
+		buf:        make([]byte, 0, opts.BlockSize),
+		blocks:     make(chan *gzipBlock, opts.Parallel),
+		sem:        make(chan struct{}, opts.Parallel),
+		writerDone: make(chan struct{}),
+	}
+	go zw.writeBlocks(zw.blocks)
+	return zw, nil
+}
+
+func (zw *parallelGzipWriter) Write(p []byte) (int, error) {
+	if err := zw.error(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := zw.opts.BlockSize - len(zw.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		zw.buf = append(zw.buf, p[:room]...)
+		p = p[room:]
+		if len(zw.buf) == zw.opts.BlockSize {
+			zw.startBlock()
+		}
+	}
+	return n, nil
+}
+
+// startBlock starts compressing the buffered input.
+func (zw *parallelGzipWriter) startBlock() {
+	zw.started = true
+	block := &gzipBlock{data: zw.buf, done: make(chan struct{})}
+	zw.buf = make([]byte, 0, zw.opts.BlockSize)
+	zw.sem <- struct{}{}
+	go func() {
+		defer func() { <-zw.sem }()
+		defer close(block.done)
+		block.err = compressBlock(&block.z, block.data, zw.opts.Level)
+		block.data = nil
+	}()
+	zw.blocks <- block
+}
+
+// compressBlock writes data to w as a single gzip member with a fixed
+// header.
+func compressBlock(w io.Writer, data []byte, level int) error {
+	gzw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	gzw.Header = gzip.Header{ModTime: time.Time{}, OS: 255}
+	if _, err := gzw.Write(data); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+// writeBlocks writes compressed blocks to the output in order.
+func (zw *parallelGzipWriter) writeBlocks(blocks <-chan *gzipBlock) {
+	defer close(zw.writerDone)
+	for block := range blocks {
+		<-block.done
+		if zw.error() != nil {
+			continue
+		}
+		err := block.err
+		if err == nil {
+			_, err = zw.w.Write(block.z.Bytes())
+		}
+		if err != nil {
+			zw.mu.Lock()
+			zw.err = err
+			zw.mu.Unlock()
+		}
+	}
+}
+
+func (zw *parallelGzipWriter) error() error {
+	zw.mu.Lock()
+	defer zw.mu.Unlock()
+	return zw.err
+}
+
+// Close compresses any remaining input and waits for all blocks to be
+// written. An empty input still produces a valid, empty gzip member.
+func (zw *parallelGzipWriter) Close() error {
+	if zw.blocks == nil {
+		return zw.error()
+	}
+	if len(zw.buf) > 0 || !zw.started {
+		zw.startBlock()
+	}
+	close(zw.blocks)
+	zw.blocks = nil
+	<-zw.writerDone
+	return zw.error()
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func gzipAll(t *testing.T, data []byte, writeSize int, opts GzipOptions) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := newParallelGzipWriter(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := data; len(p) > 0; {
+		n := writeSize
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := zw.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParallelGzipWriterIsDeterministic(t *testing.T) {
+	data := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(data[:100000])
+
+	want := gzipAll(t, data, len(data), GzipOptions{BlockSize: 64 << 10, Parallel: 1})
+	for _, tc := range []struct {
+		writeSize, parallel int
+	}{
+		{1000, 1},
+		{7, 3},
+		{1 << 20, 8},
+	} {
+		got := gzipAll(t, data, tc.writeSize, GzipOptions{BlockSize: 64 << 10, Parallel: tc.parallel})
+		if !bytes.Equal(got, want) {
+			t.Errorf("write size %d, parallel %d: output differs", tc.writeSize, tc.parallel)
+		}
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.Name != "" || !zr.ModTime.IsZero() || zr.OS != 255 {
+		t.Errorf("got gzip header %+v; want no name, zero mtime and OS 255", zr.Header)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decompressed output differs from input")
+	}
+
+	if best := gzipAll(t, data, len(data), GzipOptions{Level: gzip.BestCompression, BlockSize: 64 << 10}); len(best) >= len(gzipAll(t, data, len(data), GzipOptions{Level: gzip.BestSpeed, BlockSize: 64 << 10})) {
+		t.Errorf("best compression is not smaller than best speed")
+	}
+}
+
+func TestParallelGzipWriterEmpty(t *testing.T) {
+	zr, err := gzip.NewReader(bytes.NewReader(gzipAll(t, nil, 1, GzipOptions{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(zr); err != nil || len(got) != 0 {
+		t.Errorf("got %q, %v; want empty output", got, err)
+	}
+}
+
+func TestGzipOptionsValidation(t *testing.T) {
+	for _, opts := range []GzipOptions{{Level: 10}, {Level: -3}, {BlockSize: -1}, {Parallel: -1}} {
+		if _, err := newParallelGzipWriter(ioutil.Discard, opts); err == nil {
+			t.Errorf("newParallelGzipWriter(%+v) succeeded; want error", opts)
+		}
+	}
+}
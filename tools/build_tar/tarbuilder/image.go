@@ -0,0 +1,327 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Image formats accepted by AddImage.
+const (
+	// ImageFormatDocker is the tarball format of `docker save`, loadable
+	// with `docker load`.
+	ImageFormatDocker = "docker"
+	// ImageFormatOCI is an OCI image layout.
+	// See https://github.com/opencontainers/image-spec/blob/master/image-layout.md.
+	ImageFormatOCI = "oci"
+)
+
+const (
+	ociManifestType      = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexType         = "application/vnd.oci.image.index.v1+json"
+	ociConfigType        = "application/vnd.oci.image.config.v1+json"
+	ociLayerType         = "application/vnd.oci.image.layer.v1.tar"
+	ociGzipLayerType     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+	// containerd and docker use this annotation for the full image name.
+	containerdNameAnnotation = "io.containerd.image.name"
+)
+
+// Image describes a container image to add to the archive.
+type Image struct {
+	// Format is ImageFormatDocker or ImageFormatOCI.
+	Format string
+	// Config is the path of the image config JSON.
+	Config string
+	// Layers are the paths of the layer tarballs, from lowest to highest,
+	// either uncompressed or gzipped.
+	Layers []string
+	// Tags are image references such as "registry/repo:tag". A reference
+	// without a tag is tagged "latest".
+	Tags []string
+}
+
+// imageBlob is the content of a config or layer.
+type imageBlob struct {
+	data   []byte
+	digest string
+	// diffID is the digest of an uncompressed layer.
+	diffID string
+	gzip   bool
+}
+
+func (blob imageBlob) hex() string {
+	return strings.TrimPrefix(blob.digest, "sha256:")
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// AddImage adds img to the archive root, ignoring the archive's directory.
+// The digests of the layers are checked against the rootfs.diff_ids of the
+// config, if it lists any.
+func (b *Builder) AddImage(img Image) error {
+	b.stats.setSource("image", "")
+	var inputs []FileInput
+	for _, src := range append([]string{img.Config}, img.Layers...) {
+		inputs = append(inputs, FileInput{Src: src})
+	}
+	done := make(chan struct{})
+	defer close(done)
+	var blobs []imageBlob
+	for ch := range prefetchFiles(inputs, nil, b.jobs, done) {
+		pf := <-ch
+		blob, err := newImageBlob(pf)
+		pf.finish()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, blob)
+	}
+	config, layers := blobs[0], blobs[1:]
+	if err := checkDiffIDs(img.Config, config.data, layers); err != nil {
+		return err
+	}
+
+	tags := make([][2]string, len(img.Tags))
+	for i, tag := range img.Tags {
+		tags[i][0], tags[i][1] = splitTag(tag)
+	}
+
+	switch img.Format {
+	case ImageFormatDocker:
+		return b.addDockerImage(config, layers, tags)
+	case ImageFormatOCI:
+		return b.addOCIImage(config, layers, img.Tags, tags)
+	}
+	return fmt.Errorf("unknown image format %q, want %s or %s", img.Format, ImageFormatDocker, ImageFormatOCI)
+}
+
+func newImageBlob(pf *prefetchedFile) (imageBlob, error) {
+	if pf.err != nil {
+		return imageBlob{}, pf.err
+	}
+	if !pf.info.Mode().IsRegular() {
+		return imageBlob{}, fmt.Errorf("%s is not a regular file", pf.Src)
+	}
+	// The blobs are all kept until the image is written.
+	if err := pf.load(); err != nil {
+		return imageBlob{}, err
+	}
+	if pf.sparse != nil {
+		pf.data = pf.sparse.expand(pf.data)
+	}
+	blob := imageBlob{
+		data:   pf.data,
+		digest: fmt.Sprintf("sha256:%x", pf.sha256),
+	}
+	blob.diffID = blob.digest
+	if bytes.HasPrefix(pf.data, []byte{0x1f, 0x8b}) {
+		blob.gzip = true
+		zr, err := gzip.NewReader(bytes.NewReader(pf.data))
+		if err != nil {
+			return imageBlob{}, fmt.Errorf("%s: %v", pf.Src, err)
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, zr); err != nil {
+			return imageBlob{}, fmt.Errorf("%s: %v", pf.Src, err)
+		}
+		blob.diffID = fmt.Sprintf("sha256:%x", h.Sum(nil))
+	}
+	return blob, nil
+}
+
+// checkDiffIDs checks the layers against the diff IDs listed in config.
+func checkDiffIDs(path string, config []byte, layers []imageBlob) error {
+	var c struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return fmt.Errorf("couldn't parse image config %s: %v", path, err)
+	}
+	diffIDs := c.RootFS.DiffIDs
+	if len(diffIDs) == 0 {
+		return nil
+	}
+	if len(diffIDs) != len(layers) {
+		return fmt.Errorf("image config %s lists %d layers, got %d", path, len(diffIDs), len(layers))
+	}
+	for i, layer := range layers {
+		if layer.diffID != diffIDs[i] {
+			return fmt.Errorf("layer %d has diff ID %s, image config %s expects %s", i, layer.diffID, path, diffIDs[i])
+		}
+	}
+	return nil
+}
+
+// splitTag splits an image reference into its repository and tag.
+func splitTag(ref string) (string, string) {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+func (b *Builder) addDockerImage(config imageBlob, layers []imageBlob, tags [][2]string) error {
+	manifest := dockerManifest{
+		Config:   config.hex() + ".json",
+		RepoTags: []string{},
+	}
+	if err := b.addImageFile(manifest.Config, config.data); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		name := path.Join(layer.hex(), "layer.tar")
+		if err := b.addImageFile(name, layer.data); err != nil {
+			return err
+		}
+		manifest.Layers = append(manifest.Layers, name)
+	}
+
+	repositories := map[string]map[string]string{}
+	for _, tag := range tags {
+		manifest.RepoTags = append(manifest.RepoTags, tag[0]+":"+tag[1])
+		if len(layers) > 0 {
+			if repositories[tag[0]] == nil {
+				repositories[tag[0]] = map[string]string{}
+			}
+			repositories[tag[0]][tag[1]] = layers[len(layers)-1].hex()
+		}
+	}
+
+	if err := b.addImageJSON("manifest.json", []dockerManifest{manifest}); err != nil {
+		return err
+	}
+	return b.addImageJSON("repositories", repositories)
+}
+
+func (b *Builder) addOCIImage(config imageBlob, layers []imageBlob, refs []string, tags [][2]string) error {
+	if err := b.addImageJSON("oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"}); err != nil {
+		return err
+	}
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		Config:        ociDescriptor{MediaType: ociConfigType, Digest: config.digest, Size: len(config.data)},
+		Layers:        []ociDescriptor{},
+	}
+	if err := b.addImageFile(path.Join("blobs/sha256", config.hex()), config.data); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		mediaType := ociLayerType
+		if layer.gzip {
+			mediaType = ociGzipLayerType
+		}
+		manifest.Layers = append(manifest.Layers, ociDescriptor{MediaType: mediaType, Digest: layer.digest, Size: len(layer.data)})
+		if err := b.addImageFile(path.Join("blobs/sha256", layer.hex()), layer.data); err != nil {
+			return err
+		}
+	}
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifestData))
+	if err := b.addImageFile(path.Join("blobs/sha256", strings.TrimPrefix(manifestDigest, "sha256:")), manifestData); err != nil {
+		return err
+	}
+
+	index := ociIndex{SchemaVersion: 2, MediaType: ociIndexType}
+	descriptor := ociDescriptor{MediaType: ociManifestType, Digest: manifestDigest, Size: len(manifestData)}
+	for i, tag := range tags {
+		d := descriptor
+		d.Annotations = map[string]string{
+			ociRefNameAnnotation:     tag[1],
+			containerdNameAnnotation: refs[i],
+		}
+		index.Manifests = append(index.Manifests, d)
+	}
+	if len(tags) == 0 {
+		index.Manifests = []ociDescriptor{descriptor}
+	}
+	return b.addImageJSON("index.json", index)
+}
+
+func (b *Builder) addImageJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.addImageFile(name, data)
+}
+
+// addImageFile adds a regular file at name in the archive root. Blobs
+// named by their digest are only added once.
+func (b *Builder) addImageFile(name string, data []byte) error {
+	if ok := b.tryReservePath(name); !ok {
+		if strings.HasPrefix(name, "blobs/") || strings.HasSuffix(name, "/layer.tar") {
+			return nil
+		}
+		return fmt.Errorf("duplicate image file %s in archive", name)
+	}
+	header := tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Uid:      b.meta.DefaultUID,
+		Gid:      b.meta.DefaultGID,
+		Uname:    b.meta.DefaultUname,
+		Gname:    b.meta.DefaultGname,
+		Size:     int64(len(data)),
+		ModTime:  b.meta.ModTime,
+	}
+	if err := b.makeDirs(header, header.Size); err != nil {
+		return err
+	}
+	return b.writeEntry(&header, data, sha256.Sum256(data))
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestAddImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := writeTestTar(t, dir, "base.tar", []tar.Header{
+		{Name: "etc/hostname", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/hostname": "base"})
+	baseData, err := ioutil.ReadFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := writeTestTar(t, dir, "app.tar", []tar.Header{
+		{Name: "app", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{"app": "app"})
+	appData, err := ioutil.ReadFile(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(appData)
+	zw.Close()
+	appGz := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(appGz, gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := func(data []byte) string { return fmt.Sprintf("sha256:%x", sha256.Sum256(data)) }
+	hex := func(data []byte) string { return fmt.Sprintf("%x", sha256.Sum256(data)) }
+	configData := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[%q,%q]}}`, digest(baseData), digest(appData)))
+	config := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(config, configData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(img Image) (map[string][]byte, error) {
+		var buf bytes.Buffer
+		b, err := New(&buf, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddImage(img); err != nil {
+			return nil, err
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+		files := map[string][]byte{}
+		tr := tar.NewReader(&buf)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				return files, nil
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Typeflag == tar.TypeReg {
+				files[h.Name], _ = ioutil.ReadAll(tr)
+			}
+		}
+	}
+	names := func(files map[string][]byte) []string {
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	files, err := build(Image{Format: ImageFormatDocker, Config: config, Layers: []string{base, appGz}, Tags: []string{"example.com:5000/app", "app:v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNames := []string{
+		hex(configData) + ".json",
+		hex(baseData) + "/layer.tar",
+		hex(gz.Bytes()) + "/layer.tar",
+		"manifest.json",
+		"repositories",
+	}
+	sort.Strings(wantNames)
+	if got := names(files); !reflect.DeepEqual(got, wantNames) {
+		t.Errorf("docker image has files %q; want %q", got, wantNames)
+	}
+	wantManifest := fmt.Sprintf(`[{"Config":"%s.json","RepoTags":["example.com:5000/app:latest","app:v1"],"Layers":["%s/layer.tar","%s/layer.tar"]}]`, hex(configData), hex(baseData), hex(gz.Bytes()))
+	if got := string(files["manifest.json"]); got != wantManifest {
+		t.Errorf("manifest.json = %s\nwant %s", got, wantManifest)
+	}
+	wantRepositories := fmt.Sprintf(`{"app":{"v1":"%[1]s"},"example.com:5000/app":{"latest":"%[1]s"}}`, hex(gz.Bytes()))
+	if got := string(files["repositories"]); got != wantRepositories {
+		t.Errorf("repositories = %s\nwant %s", got, wantRepositories)
+	}
+
+	files, err = build(Image{Format: ImageFormatOCI, Config: config, Layers: []string{base, appGz}, Tags: []string{"app:v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(files["index.json"], &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Annotations[ociRefNameAnnotation] != "v1" {
+		t.Fatalf("index.json = %s; want one manifest tagged v1", files["index.json"])
+	}
+	manifestData := files["blobs/sha256/"+index.Manifests[0].Digest[len("sha256:"):]]
+	if digest(manifestData) != index.Manifests[0].Digest || len(manifestData) != index.Manifests[0].Size {
+		t.Errorf("index.json does not match manifest blob %s", manifestData)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	wantLayers := []ociDescriptor{
+		{MediaType: ociLayerType, Digest: digest(baseData), Size: len(baseData)},
+		{MediaType: ociGzipLayerType, Digest: digest(gz.Bytes()), Size: gz.Len()},
+	}
+	if !reflect.DeepEqual(manifest.Layers, wantLayers) || manifest.Config.Digest != digest(configData) {
+		t.Errorf("manifest = %s", manifestData)
+	}
+	for _, data := range [][]byte{configData, baseData, gz.Bytes()} {
+		if !bytes.Equal(files["blobs/sha256/"+hex(data)], data) {
+			t.Errorf("missing blob %s", hex(data))
+		}
+	}
+	if got := string(files["oci-layout"]); got != `{"imageLayoutVersion":"1.0.0"}` {
+		t.Errorf("oci-layout = %s", got)
+	}
+
+	if _, err := build(Image{Format: ImageFormatOCI, Config: config, Layers: []string{app, base}}); err == nil {
+		t.Errorf("AddImage() with layers not matching the config succeeded; want error")
+	}
+	if _, err := build(Image{Format: "aci", Config: config, Layers: []string{base, app}}); err == nil {
+		t.Errorf("AddImage() with unknown format succeeded; want error")
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entryField is a single named piece of header metadata or content.
+type entryField struct {
+	name, value string
+}
+
+// entryInfo describes a tar entry for Inspect and Diff.
+type entryInfo struct {
+	// key identifies the entry; it is the entry name, suffixed with the
+	// occurrence number if the name appears more than once in the tar.
+	key    string
+	fields []entryField
+}
+
+func (e entryInfo) String() string {
+	parts := []string{e.key}
+	for _, f := range e.fields {
+		parts = append(parts, f.name+"="+f.value)
+	}
+	return strings.Join(parts, " ")
+}
+
+func typeflagName(t byte) string {
+	switch t {
+	case tar.TypeReg, tar.TypeRegA:
+		return "file"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeFifo:
+		return "fifo"
+	case tar.TypeGNUSparse:
+		return "sparse"
+	default:
+		return fmt.Sprintf("type(%q)", t)
+	}
+}
+
+func newEntryInfo(key string, e tarEntry) entryInfo {
+	h := e.header
+	fields := []entryField{
+		{"type", typeflagName(h.Typeflag)},
+		{"mode", fmt.Sprintf("%04o", h.Mode)},
+		{"uid", strconv.Itoa(h.Uid)},
+		{"gid", strconv.Itoa(h.Gid)},
+		{"uname", strconv.Quote(h.Uname)},
+		{"gname", strconv.Quote(h.Gname)},
+		{"size", strconv.FormatInt(h.Size, 10)},
+		{"mtime", h.ModTime.UTC().Format(time.RFC3339Nano)},
+	}
+	if h.Typeflag == tar.TypeLink || h.Typeflag == tar.TypeSymlink {
+		fields = append(fields, entryField{"linkname", strconv.Quote(h.Linkname)})
+	}
+	if h.Typeflag == tar.TypeChar || h.Typeflag == tar.TypeBlock {
+		fields = append(fields, entryField{"dev", fmt.Sprintf("%d,%d", h.Devmajor, h.Devminor)})
+	}
+	if h.Typeflag == tar.TypeReg || h.Typeflag == tar.TypeRegA {
+		fields = append(fields, entryField{"sha256", fmt.Sprintf("%x", e.sha256)})
+	}
+
+	// PAX records carry xattrs (SCHILY.xattr.*) among other things. Records
+	// that archive/tar already decodes into header fields are skipped.
+	var keys []string
+	for k := range h.PAXRecords {
+		switch k {
+		case "path", "linkpath", "size", "uid", "gid", "uname", "gname", "mtime", "atime", "ctime":
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, entryField{"pax." + k, strconv.Quote(h.PAXRecords[k])})
+	}
+	return entryInfo{key: key, fields: fields}
+}
+
+// readEntryInfos reads every entry of a possibly compressed tar, in archive
+// order.
+func readEntryInfos(path string) ([]entryInfo, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	var infos []entryInfo
+	seen := map[string]int{}
+	for e := range prefetchTar(path, newPrefetchBudget(tarPrefetchBytes), done) {
+		if e.err != nil {
+			return nil, e.err
+		}
+		err := e.hash()
+		e.finish()
+		if err != nil {
+			return nil, err
+		}
+		key := e.header.Name
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			key = fmt.Sprintf("%s#%d", key, n)
+		}
+		infos = append(infos, newEntryInfo(key, e))
+	}
+	return infos, nil
+}
+
+// Inspect writes one line per entry of the tar at path to w.
+func Inspect(w io.Writer, path string) error {
+	infos, err := readEntryInfos(path)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if _, err := fmt.Fprintln(w, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diff writes the entries added, removed and modified between the tars
+// at a and b to w. It reports whether any differences were found.
+func Diff(w io.Writer, a, b string) (bool, error) {
+	before, err := readEntryInfos(a)
+	if err != nil {
+		return false, err
+	}
+	after, err := readEntryInfos(b)
+	if err != nil {
+		return false, err
+	}
+
+	beforeByKey := map[string]entryInfo{}
+	for _, info := range before {
+		beforeByKey[info.key] = info
+	}
+	afterByKey := map[string]entryInfo{}
+	for _, info := range after {
+		afterByKey[info.key] = info
+	}
+
+	var lines []string
+	for _, info := range before {
+		if _, ok := afterByKey[info.key]; !ok {
+			lines = append(lines, "- "+info.String())
+		}
+	}
+	for _, info := range after {
+		old, ok := beforeByKey[info.key]
+		if !ok {
+			lines = append(lines, "+ "+info.String())
+			continue
+		}
+		if changes := diffFields(old.fields, info.fields); len(changes) > 0 {
+			lines = append(lines, "~ "+info.key+" "+strings.Join(changes, " "))
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return false, err
+		}
+	}
+	return len(lines) > 0, nil
+}
+
+// diffFields describes the fields that differ between a and b as
+// name=old->new, with missing fields shown as empty.
+func diffFields(a, b []entryField) []string {
+	values := func(fields []entryField) map[string]string {
+		m := map[string]string{}
+		for _, f := range fields {
+			m[f.name] = f.value
+		}
+		return m
+	}
+	av, bv := values(a), values(b)
+
+	var names []string
+	for _, f := range a {
+		names = append(names, f.name)
+	}
+	for _, f := range b {
+		if _, ok := av[f.name]; !ok {
+			names = append(names, f.name)
+		}
+	}
+
+	var changes []string
+	for _, name := range names {
+		if av[name] != bv[name] {
+			changes = append(changes, fmt.Sprintf("%s=%s->%s", name, av[name], bv[name]))
+		}
+	}
+	return changes
+}
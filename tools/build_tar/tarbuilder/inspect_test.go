@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTar writes a tar at dir/name containing the given headers, each
+// with content if it is a regular file.
+func writeTestTar(t *testing.T, dir, name string, headers []tar.Header, content map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range headers {
+		h := h
+		data := content[h.Name]
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := writeTestTar(t, dir, "a.tar", []tar.Header{
+		{Name: "same", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "mode", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "content", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "xattr", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "removed", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"content": "a"})
+	b := writeTestTar(t, dir, "b.tar", []tar.Header{
+		{Name: "same", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "mode", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "content", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "xattr", Typeflag: tar.TypeReg, Mode: 0644, PAXRecords: map[string]string{"SCHILY.xattr.user.k": "v"}},
+		{Name: "added", Typeflag: tar.TypeSymlink, Linkname: "same", Mode: 0777},
+	}, map[string]string{"content": "b"})
+
+	var out bytes.Buffer
+	differ, err := Diff(&out, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !differ {
+		t.Errorf("Diff reported no differences")
+	}
+	want := `- removed type=file mode=0644 uid=0 gid=0 uname="" gname="" size=0 mtime=1970-01-01T00:00:00Z sha256=e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+~ mode mode=0644->0755
+~ content sha256=ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb->3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d
+~ xattr pax.SCHILY.xattr.user.k=->"v"
++ added type=symlink mode=0777 uid=0 gid=0 uname="" gname="" size=0 mtime=1970-01-01T00:00:00Z linkname="same"
+`
+	if got := out.String(); got != want {
+		t.Errorf("Diff() =\n%s\nwant\n%s", got, want)
+	}
+
+	out.Reset()
+	differ, err = Diff(&out, a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differ || out.Len() != 0 {
+		t.Errorf("Diff(a, a) = %v, %q; want no differences", differ, out.String())
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// LinkCheck selects how links are validated when the archive is closed.
+type LinkCheck int
+
+const (
+	// LinkCheckOff skips link validation.
+	LinkCheckOff LinkCheck = iota
+	// LinkCheckWarn logs a warning for each problem found.
+	LinkCheckWarn
+	// LinkCheckError logs each problem and makes Close return an error.
+	LinkCheckError
+)
+
+// ParseLinkCheck parses "", "off", "warn" or "error".
+func ParseLinkCheck(s string) (LinkCheck, error) {
+	switch s {
+	case "", "off":
+		return LinkCheckOff, nil
+	case "warn":
+		return LinkCheckWarn, nil
+	case "error":
+		return LinkCheckError, nil
+	}
+	return LinkCheckOff, fmt.Errorf("unknown link check %q, want off, warn or error", s)
+}
+
+// maxSymlinkHops bounds symlink resolution, as MAXSYMLINKS does on Linux.
+const maxSymlinkHops = 40
+
+var (
+	errLinkOutside = errors.New("points outside the archive root")
+	errLinkCycle   = errors.New("is part of a symlink cycle")
+)
+
+// resolve follows symlinks written to the archive to resolve name, which
+// must be clean and relative to the archive root. Absolute symlink targets
+// are resolved against the archive root.
+func (b *Builder) resolve(name string) (string, error) {
+	var resolved []string
+	pending := strings.Split(name, "/")
+	hops := 0
+	for len(pending) > 0 {
+		c := pending[0]
+		pending = pending[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", errLinkOutside
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		cur := strings.Join(append(resolved, c), "/")
+		target, ok := b.symlinks[cur]
+		if !ok {
+			resolved = append(resolved, c)
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", errLinkCycle
+		}
+		if strings.HasPrefix(target, "/") {
+			resolved = nil
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return strings.Join(resolved, "/"), nil
+}
+
+// linkProblems returns a description of each dangling, escaping or cyclic
+// link written to the archive, sorted by link path.
+func (b *Builder) linkProblems() []string {
+	var problems []string
+	for name, target := range b.symlinks {
+		dest := target
+		if !strings.HasPrefix(target, "/") {
+			dest = filepath.Dir(name) + "/" + target
+		}
+		resolved, err := b.resolve(dest)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("symlink %s -> %s %v", name, target, err))
+			continue
+		}
+		if _, ok := b.written[resolved]; !ok && resolved != "" {
+			problems = append(problems, fmt.Sprintf("symlink %s -> %s is dangling", name, target))
+		}
+	}
+	for name, target := range b.hardlinks {
+		dest, err := cleanArchivePath(target)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("hardlink %s -> %s %v", name, target, errLinkOutside))
+			continue
+		}
+		switch typ, ok := b.written[dest]; {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("hardlink %s -> %s is dangling", name, target))
+		case typ == tar.TypeDir:
+			problems = append(problems, fmt.Sprintf("hardlink %s -> %s points to a directory", name, target))
+		case b.forwardLinks[name]:
+			problems = append(problems, fmt.Sprintf("hardlink %s -> %s comes before its target", name, target))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// checkLinks validates links according to the builder's LinkCheck.
+func (b *Builder) checkLinks() error {
+	if b.linkCheck == LinkCheckOff {
+		return nil
+	}
+	problems := b.linkProblems()
+	for _, p := range problems {
+		klog.Warningf("Bad link in archive: %s", p)
+	}
+	if b.linkCheck == LinkCheckError && len(problems) > 0 {
+		return fmt.Errorf("found %d bad links in archive: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLinkProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "usr/lib", Typeflag: tar.TypeSymlink, Linkname: "../lib", Mode: 0777},
+		{Name: "bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin", Mode: 0777},
+		{Name: "ok-rel", Typeflag: tar.TypeSymlink, Linkname: "bin/tool", Mode: 0777},
+		{Name: "ok-abs", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/tool", Mode: 0777},
+		{Name: "ok-dir", Typeflag: tar.TypeSymlink, Linkname: "usr/bin/..", Mode: 0777},
+		{Name: "dangling", Typeflag: tar.TypeSymlink, Linkname: "usr/bin/missing", Mode: 0777},
+		{Name: "outside", Typeflag: tar.TypeSymlink, Linkname: "../../etc", Mode: 0777},
+		{Name: "loop-a", Typeflag: tar.TypeSymlink, Linkname: "loop-b", Mode: 0777},
+		{Name: "loop-b", Typeflag: tar.TypeSymlink, Linkname: "loop-a", Mode: 0777},
+		{Name: "hard-ok", Typeflag: tar.TypeLink, Linkname: "usr/bin/tool", Mode: 0755},
+		{Name: "hard-dangling", Typeflag: tar.TypeLink, Linkname: "usr/bin/gone", Mode: 0755},
+		{Name: "hard-early", Typeflag: tar.TypeLink, Linkname: "late", Mode: 0644},
+		{Name: "late", Typeflag: tar.TypeReg, Mode: 0644},
+	}, nil)
+
+	for _, tc := range []struct {
+		check   LinkCheck
+		wantErr bool
+	}{
+		{LinkCheckOff, false},
+		{LinkCheckWarn, false},
+		{LinkCheckError, true},
+	} {
+		b, err := New(ioutil.Discard, Options{LinkCheck: tc.check})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddTar(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); (err != nil) != tc.wantErr {
+			t.Errorf("LinkCheck %d: Close() = %v; want error %v", tc.check, err, tc.wantErr)
+		}
+
+		want := []string{
+			"hardlink hard-dangling -> usr/bin/gone is dangling",
+			"hardlink hard-early -> late comes before its target",
+			"symlink dangling -> usr/bin/missing is dangling",
+			"symlink loop-a -> loop-b is part of a symlink cycle",
+			"symlink loop-b -> loop-a is part of a symlink cycle",
+			"symlink outside -> ../../etc points outside the archive root",
+			"symlink usr/lib -> ../lib is dangling",
+		}
+		if got := b.linkProblems(); !reflect.DeepEqual(got, want) {
+			t.Errorf("linkProblems() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
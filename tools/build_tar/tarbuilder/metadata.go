@@ -0,0 +1,329 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata holds the mode, owner and mtime policies applied to entries.
+// The per-path maps are keyed on the destination path without a leading
+// slash and take precedence over the defaults.
+type Metadata struct {
+	DefaultGID, DefaultUID int
+	GIDs, UIDs             map[string]int
+
+	DefaultGname, DefaultUname string
+	Gnames, Unames             map[string]string
+
+	// DefaultMode of zero derives the mode from the source file.
+	DefaultMode os.FileMode
+	Modes       map[string]os.FileMode
+
+	// OverrideMerged applies the defaults to entries merged from tars too.
+	// Otherwise they keep their own mode and owners unless a per-path value
+	// is set for them.
+	OverrideMerged bool
+
+	// ModTime is the mtime of added entries unless PreserveMtime is set.
+	ModTime time.Time
+	// PreserveMtime uses each source file's own mtime instead of ModTime.
+	PreserveMtime bool
+	// ClampTime, if not zero, is the latest timestamp written for any
+	// entry, including entries of merged tars. Later timestamps are
+	// clamped to it, following reproducible-builds.org conventions.
+	ClampTime time.Time
+}
+
+// ParseMtime matches the functionality of Bazel's python-based build_tar and archive modules
+// for the --mtime flag.
+// In particular:
+// - if no value is provided, use the Unix epoch
+// - if the string "portable" is provided, use a "deterministic date compatible with non *nix OSes"
+// - if an integer is provided, interpret that as the number of seconds since Unix epoch
+func ParseMtime(input string) (time.Time, error) {
+	if input == "" {
+		return time.Unix(0, 0), nil
+	} else if input == "portable" {
+		// A deterministic time compatible with non *nix OSes.
+		// See also https://github.com/bazelbuild/bazel/issues/1299.
+		return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	seconds, err := strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		return time.Unix(0, 0), err
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// SourceDateEpoch returns the time set by the SOURCE_DATE_EPOCH environment
+// variable, and whether it is set.
+// See https://reproducible-builds.org/specs/source-date-epoch/.
+func SourceDateEpoch() (time.Time, bool, error) {
+	v, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok || v == "" {
+		return time.Time{}, false, nil
+	}
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", v, err)
+	}
+	return time.Unix(seconds, 0), true, nil
+}
+
+// FlagError is an invalid value of a command-line flag.
+type FlagError struct {
+	// Flag is the name of the flag, without dashes.
+	Flag  string
+	Value string
+	Err   error
+}
+
+func (e *FlagError) Error() string {
+	return fmt.Sprintf("invalid value %q for --%s: %v", e.Value, e.Flag, e.Err)
+}
+
+func (e *FlagError) Unwrap() error {
+	return e.Err
+}
+
+// FlagErrors lists every invalid flag value found.
+type FlagErrors []*FlagError
+
+func (e FlagErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ParseMetadata builds Metadata from the values of build_tar's --mode,
+// --modes, --owner, --owners, --owner_name and --owner_names flags.
+// It returns a FlagErrors listing every invalid value.
+func ParseMetadata(
+	mode string,
+	modes []string,
+	owner string,
+	owners []string,
+	ownerName string,
+	ownerNames []string,
+	modTime time.Time,
+) (Metadata, error) {
+	meta := Metadata{
+		ModTime: modTime,
+	}
+	var errs FlagErrors
+	invalid := func(flag, value string, err error) {
+		errs = append(errs, &FlagError{Flag: flag, Value: value, Err: err})
+	}
+
+	if mode != "" {
+		i, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			invalid("mode", mode, errors.New("not an octal mode"))
+		}
+		meta.DefaultMode = os.FileMode(i)
+	}
+
+	meta.Modes = map[string]os.FileMode{}
+	for _, filemode := range modes {
+		parts := strings.SplitN(filemode, "=", 2)
+		if len(parts) != 2 {
+			invalid("modes", filemode, errors.New("want path=mode"))
+			continue
+		}
+		if parts[0] != "" && parts[0][0] == '/' {
+			parts[0] = parts[0][1:]
+		}
+		i, err := strconv.ParseUint(parts[1], 8, 32)
+		if err != nil {
+			invalid("modes", filemode, errors.New("not an octal mode"))
+			continue
+		}
+		meta.Modes[parts[0]] = os.FileMode(i)
+	}
+
+	if ownerName != "" {
+		parts := strings.SplitN(ownerName, ".", 2)
+		if len(parts) != 2 {
+			invalid("owner_name", ownerName, errors.New("want user.group"))
+		} else {
+			meta.DefaultUname = parts[0]
+			meta.DefaultGname = parts[1]
+		}
+	}
+
+	meta.Unames = map[string]string{}
+	meta.Gnames = map[string]string{}
+	for _, name := range ownerNames {
+		parts := strings.SplitN(name, "=", 2)
+		if len(parts) != 2 {
+			invalid("owner_names", name, errors.New("want path=user.group"))
+			continue
+		}
+		filename, ownername := strings.TrimPrefix(parts[0], "/"), parts[1]
+
+		parts = strings.SplitN(ownername, ".", 2)
+		if len(parts) != 2 {
+			invalid("owner_names", name, errors.New("want path=user.group"))
+			continue
+		}
+		uname, gname := parts[0], parts[1]
+
+		meta.Unames[filename] = uname
+		meta.Gnames[filename] = gname
+	}
+
+	if owner != "" {
+		uid, gid, err := parseOwner(owner)
+		if err != nil {
+			invalid("owner", owner, err)
+		}
+		meta.DefaultUID = uid
+		meta.DefaultGID = gid
+	}
+
+	meta.UIDs = map[string]int{}
+	meta.GIDs = map[string]int{}
+	for _, owner := range owners {
+		parts := strings.SplitN(owner, "=", 2)
+		if len(parts) != 2 {
+			invalid("owners", owner, errors.New("want path=uid.gid"))
+			continue
+		}
+		uid, gid, err := parseOwner(parts[1])
+		if err != nil {
+			invalid("owners", owner, err)
+			continue
+		}
+		filename := strings.TrimPrefix(parts[0], "/")
+		meta.UIDs[filename] = uid
+		meta.GIDs[filename] = gid
+	}
+
+	if len(errs) > 0 {
+		return meta, errs
+	}
+	return meta, nil
+}
+
+// parseOwner parses a numeric uid.gid pair.
+func parseOwner(owner string) (int, int, error) {
+	parts := strings.SplitN(owner, ".", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.New("want uid.gid")
+	}
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("uid %q is not a number", parts[0])
+	}
+	gid, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("gid %q is not a number", parts[1])
+	}
+	return uid, gid, nil
+}
+
+func (m *Metadata) getGID(fname string) int {
+	if id, ok := m.GIDs[fname]; ok {
+		return id
+	}
+	return m.DefaultGID
+}
+
+func (m *Metadata) getUID(fname string) int {
+	if id, ok := m.UIDs[fname]; ok {
+		return id
+	}
+	return m.DefaultUID
+}
+
+func (m *Metadata) getGname(fname string) string {
+	if name, ok := m.Gnames[fname]; ok {
+		return name
+	}
+	return m.DefaultGname
+}
+
+func (m *Metadata) getUname(fname string) string {
+	if name, ok := m.Unames[fname]; ok {
+		return name
+	}
+	return m.DefaultUname
+}
+
+func (m *Metadata) getMode(fname string) os.FileMode {
+	if mode, ok := m.Modes[fname]; ok {
+		return mode
+	}
+	return m.DefaultMode
+}
+
+// applyToMerged sets the mode and owners of an entry merged from a tar
+// from the per-path values for its final path, falling back to the
+// defaults only if OverrideMerged is set. Symlinks keep their mode.
+func (m *Metadata) applyToMerged(h *tar.Header) {
+	name := strings.TrimSuffix(h.Name, "/")
+	if mode, ok := m.Modes[name]; ok && h.Typeflag != tar.TypeSymlink {
+		h.Mode = int64(mode)
+	} else if m.OverrideMerged && m.DefaultMode != 0 && h.Typeflag != tar.TypeSymlink {
+		h.Mode = int64(m.DefaultMode)
+	}
+	if _, ok := m.UIDs[name]; ok || m.OverrideMerged {
+		h.Uid = m.getUID(name)
+		h.Gid = m.getGID(name)
+	}
+	if _, ok := m.Unames[name]; ok || m.OverrideMerged {
+		h.Uname = m.getUname(name)
+		h.Gname = m.getGname(name)
+	}
+}
+
+// modTime returns the mtime for an entry whose source was last modified at
+// srcTime.
+func (m *Metadata) modTime(srcTime time.Time) time.Time {
+	if m.PreserveMtime {
+		return m.clamp(srcTime)
+	}
+	return m.ModTime
+}
+
+// clamp limits t to ClampTime, if set.
+func (m *Metadata) clamp(t time.Time) time.Time {
+	if !m.ClampTime.IsZero() && t.After(m.ClampTime) {
+		return m.ClampTime
+	}
+	return t
+}
+
+// clampHeader clamps the timestamps of a merged entry.
+func (m *Metadata) clampHeader(h *tar.Header) {
+	h.ModTime = m.clamp(h.ModTime)
+	if !h.AccessTime.IsZero() {
+		h.AccessTime = m.clamp(h.AccessTime)
+	}
+	if !h.ChangeTime.IsZero() {
+		h.ChangeTime = m.clamp(h.ChangeTime)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceDateEpoch(t *testing.T) {
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	os.Unsetenv("SOURCE_DATE_EPOCH")
+	if _, ok, err := SourceDateEpoch(); ok || err != nil {
+		t.Errorf("SourceDateEpoch() with no variable = %v, %v; want unset", ok, err)
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	if got, ok, err := SourceDateEpoch(); !ok || err != nil || got.Unix() != 1600000000 {
+		t.Errorf("SourceDateEpoch() = %v, %v, %v; want 1600000000", got, ok, err)
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, _, err := SourceDateEpoch(); err == nil {
+		t.Errorf("SourceDateEpoch() with invalid variable succeeded; want error")
+	}
+}
+
+func TestPreserveMtimeClamps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clamp := time.Unix(1600000000, 0)
+	old := time.Unix(1500000000, 0)
+	recent := time.Unix(1700000000, 0)
+
+	for name, mtime := range map[string]time.Time{"old": old, "recent": recent} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "merged-old", Typeflag: tar.TypeReg, Mode: 0644, ModTime: old},
+		{Name: "merged-recent", Typeflag: tar.TypeReg, Mode: 0644, ModTime: recent},
+	}, nil)
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{Meta: Metadata{PreserveMtime: true, ClampTime: clamp}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"old", "recent"} {
+		if err := b.AddFile(filepath.Join(dir, name), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.AddTar(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]time.Time{
+		"old":           old,
+		"recent":        clamp,
+		"merged-old":    old,
+		"merged-recent": clamp,
+	}
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.ModTime.Equal(want[h.Name]) {
+			t.Errorf("%s has mtime %v; want %v", h.Name, h.ModTime, want[h.Name])
+		}
+		delete(want, h.Name)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing entries: %v", want)
+	}
+}
+
+func TestMergedMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "usr/local/bin/kubectl", Typeflag: tar.TypeReg, Mode: 0755, Uid: 1000, Gid: 1000},
+		{Name: "usr/local/bin/kc", Typeflag: tar.TypeSymlink, Linkname: "kubectl", Mode: 0777, Uid: 1000, Gid: 1000},
+		{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0600, Uid: 1000, Gid: 1000},
+	}, nil)
+
+	meta, err := ParseMetadata("0644", []string{"/usr/local/bin/kubectl=0500", "usr/local/bin/kc=0700"}, "0.0",
+		[]string{"/usr/local/bin/kubectl=65532.65532"}, "", []string{"usr/local/bin/kubectl=nonroot.nonroot"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		override bool
+		want     []string
+	}{
+		{
+			want: []string{
+				"usr/ 5 0700 65532:65532 nonroot",
+				"usr/local/ 5 0700 65532:65532 nonroot",
+				"usr/local/bin/ 5 0700 65532:65532 nonroot",
+				"usr/local/bin/kubectl 0500 65532:65532 nonroot",
+				"usr/local/bin/kc 0777 1000:1000 ",
+				"etc/ 5 0700 1000:1000 ",
+				"etc/config 0600 1000:1000 ",
+			},
+		},
+		{
+			override: true,
+			want: []string{
+				"usr/ 5 0700 65532:65532 nonroot",
+				"usr/local/ 5 0700 65532:65532 nonroot",
+				"usr/local/bin/ 5 0700 65532:65532 nonroot",
+				"usr/local/bin/kubectl 0500 65532:65532 nonroot",
+				"usr/local/bin/kc 0777 0:0 ",
+				"etc/ 5 0755 0:0 ",
+				"etc/config 0644 0:0 ",
+			},
+		},
+	} {
+		meta.OverrideMerged = tc.override
+		var buf bytes.Buffer
+		b, err := New(&buf, Options{Meta: meta})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddTar(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		tr := tar.NewReader(&buf)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			typ := ""
+			if h.Typeflag == tar.TypeDir {
+				typ = "5 "
+			}
+			got = append(got, fmt.Sprintf("%s %s%04o %d:%d %s", h.Name, typ, h.Mode, h.Uid, h.Gid, h.Uname))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("OverrideMerged=%v: got entries\n%s\nwant\n%s", tc.override, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
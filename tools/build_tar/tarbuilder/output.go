@@ -0,0 +1,273 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Output is a file the archive is written to.
+type Output struct {
+	Path string
+	// Compression is "" for none or "gz".
+	Compression string
+	// Digests lists digest algorithms, such as "sha256". For each, the hex
+	// digest of the file is written to Path.<algorithm>.
+	Digests []string
+}
+
+// digestAlgorithms maps supported digest names to their constructors.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// ParseDigests parses a comma-separated list of digest algorithms, such as
+// "sha256,sha512", as used by build_tar's --digest flag.
+func ParseDigests(list string) ([]string, error) {
+	var digests []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, ok := digestAlgorithms[name]; !ok {
+			return nil, fmt.Errorf("unknown digest %q, want md5, sha1, sha256 or sha512", name)
+		}
+		seen[name] = true
+		digests = append(digests, name)
+	}
+	return digests, nil
+}
+
+// ParseOutput parses a build_tar --output value. A plain path is compressed
+// as defaultCompression. A path:compression pair, where compression is ""
+// or "gz", also gets a sha256 digest file. "bz2" and "xz" pairs are
+// recognized but rejected, as those compressions are not supported yet.
+func ParseOutput(spec, defaultCompression string) (Output, error) {
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		switch c := spec[i+1:]; c {
+		case "", "gz", "bz2", "xz":
+			if err := CheckCompression(c); err != nil {
+				return Output{}, err
+			}
+			return Output{Path: spec[:i], Compression: c, Digests: []string{"sha256"}}, nil
+		}
+	}
+	return Output{Path: spec, Compression: defaultCompression}, nil
+}
+
+// newCompressedWriter returns a buffered writer compressing to w, and the
+// functions to run in reverse order to flush it.
+func newCompressedWriter(w io.Writer, compression string, gzipOpts GzipOptions) (io.Writer, []func() error, error) {
+	if err := CheckCompression(compression); err != nil {
+		return nil, nil, err
+	}
+	var closers []func() error
+
+	buf := bufio.NewWriter(w)
+	closers = append(closers, buf.Flush)
+	w = buf
+
+	if compression == "gz" {
+		gzw, err := newParallelGzipWriter(w, gzipOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, gzw.Close)
+		w = gzw
+	}
+	return w, closers, nil
+}
+
+// CheckCompression returns an error if outputs can't be compressed as
+// compression.
+func CheckCompression(compression string) error {
+	switch compression {
+	case "", "gz":
+		return nil
+	case "bz2", "xz":
+		return fmt.Errorf("%q compression is not supported yet", compression)
+	}
+	return fmt.Errorf("unknown compression %q", compression)
+}
+
+// CreateAll creates each of the outputs and returns a Builder writing the
+// same archive to all of them, so that inputs are only read once. Each
+// output is written to a .tmp file next to it and renamed into place by
+// Close.
+//
+// If opts.MaxVolumeSize is set, each output is split into volumes named
+// like name.000.tar, with an index like name.tar.index.json. See volumePath.
+func CreateAll(outputs []Output, opts Options) (*Builder, error) {
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no outputs")
+	}
+	open := func(volume int) (*openedOutputs, error) {
+		if opts.MaxVolumeSize <= 0 {
+			return openOutputs(outputs, opts)
+		}
+		volumes := make([]Output, len(outputs))
+		for i, out := range outputs {
+			volumes[i] = out
+			volumes[i].Path = volumePath(out.Path, volume)
+		}
+		return openOutputs(volumes, opts)
+	}
+
+	opened, err := open(0)
+	if err != nil {
+		return nil, err
+	}
+	b := newBuilder(opened.w, opened.closers, opts)
+	b.stats.outputs = opened.counters
+	b.tmpFiles = opened.tmpFiles
+	if opts.MaxVolumeSize > 0 {
+		b.volumes = &volumeSet{
+			max:        opts.MaxVolumeSize,
+			outputs:    outputs,
+			open:       open,
+			dirs:       map[string]tar.Header{},
+			made:       map[string]struct{}{},
+			entryStart: -1,
+			pending:    opened.files,
+		}
+	}
+	return b, nil
+}
+
+// openedOutputs are outputs being written.
+type openedOutputs struct {
+	// w writes to all of the outputs, and closers finish writing them.
+	w        io.Writer
+	closers  []func() error
+	counters []outputCounter
+	// tmpFiles are the files written until they are renamed into place.
+	tmpFiles []*os.File
+	// files are the paths the closers write.
+	files []string
+}
+
+// openOutputs creates the outputs.
+func openOutputs(outputs []Output, opts Options) (*openedOutputs, error) {
+	var (
+		writers []io.Writer
+		opened  openedOutputs
+	)
+	fail := func(err error) (*openedOutputs, error) {
+		removeTmpFiles(opened.tmpFiles)
+		return nil, err
+	}
+
+	for _, out := range outputs {
+		out := out
+		hashes := make([]hash.Hash, len(out.Digests))
+		for i, name := range out.Digests {
+			newHash, ok := digestAlgorithms[name]
+			if !ok {
+				return fail(fmt.Errorf("unknown digest %q for %s", name, out.Path))
+			}
+			hashes[i] = newHash()
+		}
+
+		// The output is renamed into place once complete, so that it can
+		// also be an input, as with Builder.UpdateArchive.
+		tmp := out.Path + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			return fail(err)
+		}
+		opened.tmpFiles = append(opened.tmpFiles, f)
+		counter := &countingWriter{w: f}
+		opened.counters = append(opened.counters, outputCounter{Output: out, counter: counter})
+		ws := []io.Writer{counter}
+		for _, h := range hashes {
+			ws = append(ws, h)
+		}
+		opened.files = append(opened.files, out.Path)
+		for _, name := range out.Digests {
+			opened.files = append(opened.files, out.Path+"."+name)
+		}
+		if opts.Signer != nil {
+			opened.files = append(opened.files, out.Path+".sig", out.Path+".fingerprint")
+			signHash := sha256.New()
+			ws = append(ws, signHash)
+			// The signature is written last, once the output is complete.
+			opened.closers = append(opened.closers, func() error {
+				return writeSignature(out.Path, opts.Signer, signHash.Sum(nil))
+			})
+		}
+		var w io.Writer = counter
+		if len(ws) > 1 {
+			w = io.MultiWriter(ws...)
+		}
+		// Digest files are written once the output is flushed and closed.
+		opened.closers = append(opened.closers, func() error {
+			return writeDigestFiles(out.Path, out.Digests, hashes)
+		}, func() error {
+			return os.Rename(tmp, out.Path)
+		}, f.Close)
+
+		cw, cc, err := newCompressedWriter(w, out.Compression, opts.Gzip)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %v", out.Path, err))
+		}
+		opened.closers = append(opened.closers, cc...)
+		writers = append(writers, cw)
+	}
+
+	opened.w = writers[0]
+	if len(writers) > 1 {
+		opened.w = io.MultiWriter(writers...)
+	}
+	return &opened, nil
+}
+
+// removeTmpFiles closes and removes outputs that won't be completed.
+func removeTmpFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// writeDigestFiles writes the hex digest of each hash, followed by a
+// newline, to path.<name>. This is the format of the md5sum, sha1sum and
+// sha512sum rules in defs/build.bzl.
+func writeDigestFiles(path string, names []string, hashes []hash.Hash) error {
+	for i, name := range names {
+		digest := fmt.Sprintf("%x\n", hashes[i].Sum(nil))
+		if err := ioutil.WriteFile(path+"."+name, []byte(digest), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseOutput(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want Output
+	}{
+		{"out.tar", Output{Path: "out.tar", Compression: "bz2"}},
+		{"out.tar:", Output{Path: "out.tar", Digests: []string{"sha256"}}},
+		{"out.tar.gz:gz", Output{Path: "out.tar.gz", Compression: "gz", Digests: []string{"sha256"}}},
+		{"c:/out.tar", Output{Path: "c:/out.tar", Compression: "bz2"}},
+	} {
+		if got, err := ParseOutput(tc.spec, "bz2"); err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseOutput(%q) = %+v, %v; want %+v", tc.spec, got, err, tc.want)
+		}
+	}
+	for _, spec := range []string{"out.tar.xz:xz", "out.tar.bz2:bz2"} {
+		if _, err := ParseOutput(spec, ""); err == nil {
+			t.Errorf("ParseOutput(%q) succeeded; want error", spec)
+		}
+	}
+}
+
+func TestParseDigests(t *testing.T) {
+	got, err := ParseDigests("sha256, sha512,,sha256,md5")
+	if want := []string{"sha256", "sha512", "md5"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDigests() = %q, %v; want %q", got, err, want)
+	}
+	if got, err := ParseDigests(""); err != nil || got != nil {
+		t.Errorf("ParseDigests(\"\") = %q, %v; want none", got, err)
+	}
+	if _, err := ParseDigests("sha256,crc32"); err == nil {
+		t.Errorf("ParseDigests() with unknown digest succeeded; want error")
+	}
+}
+
+func TestDigestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "empty.tar")
+	b, err := CreateAll([]Output{{Path: out, Digests: []string{"md5", "sha1", "sha512"}}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ext, sum := range map[string][]byte{
+		"md5":    md5Sum(data),
+		"sha1":   sha1Sum(data),
+		"sha512": sha512Sum(data),
+	} {
+		got, err := ioutil.ReadFile(out + "." + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("%x\n", sum); string(got) != want {
+			t.Errorf("%s.%s = %q; want %q", out, ext, got, want)
+		}
+	}
+	if _, err := os.Stat(out + ".sha256"); !os.IsNotExist(err) {
+		t.Errorf("unrequested %s.sha256 exists: %v", out, err)
+	}
+}
+
+func md5Sum(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+func sha1Sum(data []byte) []byte {
+	sum := sha1.Sum(data)
+	return sum[:]
+}
+
+func sha512Sum(data []byte) []byte {
+	sum := sha512.Sum512(data)
+	return sum[:]
+}
+
+func TestCreateAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "out.tar")
+	gz := filepath.Join(dir, "out.tar.gz")
+	b, err := CreateAll([]Output{
+		{Path: plain, Digests: []string{"sha256"}},
+		{Path: gz, Compression: "gz", Digests: []string{"sha256"}},
+	}, Options{Directory: "opt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(src, "hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	plainData, err := ioutil.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"opt/ 5 0755 0:0",
+		"opt/hello.txt 0 0644 0:0 hello",
+	}
+	if got := readTestTar(t, bytes.NewReader(plainData)); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s has entries %q; want %q", plain, got, want)
+	}
+	gzData, err := ioutil.ReadFile(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(gzData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unzipped, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unzipped, plainData) {
+		t.Errorf("%s does not decompress to %s", gz, plain)
+	}
+
+	for path, data := range map[string][]byte{plain: plainData, gz: gzData} {
+		digest, err := ioutil.ReadFile(path + ".sha256")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("%x\n", sha256.Sum256(data)); string(digest) != want {
+			t.Errorf("%s.sha256 = %q; want %q", path, digest, want)
+		}
+	}
+}
+
+func TestCreateAllErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, outputs := range [][]Output{
+		nil,
+		{{Path: filepath.Join(dir, "out.tar.xz"), Compression: "xz"}},
+		{{Path: filepath.Join(dir, "out.tar"), Digests: []string{"crc32"}}},
+	} {
+		if _, err := CreateAll(outputs, Options{}); err == nil {
+			t.Errorf("CreateAll(%+v) succeeded; want error", outputs)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out.tar")
+	b, err := CreateAll([]Output{{Path: out}, {Path: out + ".gz", Compression: "gz"}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddDir("etc"); err != nil {
+		t.Fatal(err)
+	}
+	b.Abort()
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("Abort() left %d files, %v; want none", len(files), err)
+	}
+}
+
+func TestCloseErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name      string
+		opts      Options
+		wantCheck bool
+	}{
+		{name: "check", opts: Options{PortableCheck: PortableCheckError}, wantCheck: true},
+		// The output can't be renamed over a directory.
+		{name: "rename"},
+	} {
+		out := filepath.Join(dir, tc.name, "out.tar")
+		if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if tc.name == "rename" {
+			if err := os.MkdirAll(filepath.Join(out, "x"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := CreateAll([]Output{{Path: out, Digests: []string{"sha256"}}}, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddDir("aux"); err != nil {
+			t.Fatal(err)
+		}
+		err = b.Close()
+		var checkErr *CheckError
+		if err == nil || errors.As(err, &checkErr) != tc.wantCheck {
+			t.Errorf("%s: Close() = %v; want error, CheckError %v", tc.name, err, tc.wantCheck)
+		}
+		// Nothing is finished after an error.
+		for _, name := range []string{out, out + ".sha256"} {
+			if info, err := os.Stat(name); err == nil && !info.IsDir() {
+				t.Errorf("%s: Close() wrote %s; want it left out", tc.name, name)
+			}
+		}
+		if _, err := os.Stat(out + ".tmp"); err != nil {
+			t.Errorf("%s: Close() removed %s.tmp: %v; want it left for Abort", tc.name, out, err)
+		}
+		b.Abort()
+		if _, err := os.Stat(out + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("%s: Abort() left %s.tmp: %v", tc.name, out, err)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// tarEntryDepth is the number of decoded entries buffered per input tar.
+const tarEntryDepth = 16
+
+// tarPrefetchBytes bounds the contents of the entries buffered ahead of the
+// writer across all the input tars being decoded. Entries that don't fit
+// are streamed from their tar instead. It is a variable for tests.
+var tarPrefetchBytes int64 = 64 << 20
+
+// filePrefetchBytes bounds the contents of the files read ahead of the
+// writer. Files that don't fit are streamed from disk instead. It is a
+// variable for tests.
+var filePrefetchBytes int64 = 64 << 20
+
+// prefetchBudget is the number of bytes left for buffering entries.
+type prefetchBudget struct {
+	mu   sync.Mutex
+	left int64
+}
+
+func newPrefetchBudget(n int64) *prefetchBudget {
+	return &prefetchBudget{left: n}
+}
+
+// tryAcquire takes n bytes from the budget if there are that many left.
+func (p *prefetchBudget) tryAcquire(n int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n > p.left {
+		return false
+	}
+	p.left -= n
+	return true
+}
+
+func (p *prefetchBudget) release(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.left += n
+}
+
+// FileInput is a file to add to the archive: Src is its path on disk and
+// Dest its path inside the archive.
+type FileInput struct {
+	Src, Dest string
+	// Stamp substitutes the builder's workspace status values for {KEY}
+	// placeholders in the file.
+	Stamp bool
+}
+
+// prefetchedFile is a file input that has been stat'ed, read and hashed
+// ahead of being written to the archive.
+type prefetchedFile struct {
+	FileInput
+
+	info   os.FileInfo
+	data   []byte
+	sha256 [sha256.Size]byte
+	err    error
+	// sparse, if not nil, describes the holes of a sparse file, whose
+	// data only holds the fragments.
+	sparse *sparseFile
+	// stream is set for a regular file too large to buffer, which is read
+	// from Src as it is written instead of from data.
+	stream bool
+	// finished releases the file's buffer.
+	finished func()
+}
+
+// finish releases the file's data, which counts against the prefetch
+// budget until then.
+func (pf *prefetchedFile) finish() {
+	if pf.finished != nil {
+		pf.finished()
+		pf.finished = nil
+	}
+}
+
+// load reads the contents of a streamed file into data.
+func (pf *prefetchedFile) load() error {
+	if !pf.stream {
+		return nil
+	}
+	data, err := ioutil.ReadFile(pf.Src)
+	if err != nil {
+		return err
+	}
+	pf.data, pf.sha256, pf.stream = data, sha256.Sum256(data), false
+	return nil
+}
+
+// readFileInput stats and, for regular files, reads, stamps and hashes the
+// input. A regular file that doesn't fit in budget is left to be streamed,
+// unless it is sparse or stamped, which need all of its contents.
+// Errors are recorded on the result rather than returned so that they are
+// only reported if the entry is actually written.
+func readFileInput(in FileInput, values map[string]string, budget *prefetchBudget) *prefetchedFile {
+	pf := &prefetchedFile{FileInput: in}
+	pf.info, pf.err = os.Stat(in.Src)
+	if pf.err != nil || !pf.info.Mode().IsRegular() {
+		return pf
+	}
+	if !in.Stamp {
+		pf.sparse, pf.data, pf.err = readSparseFile(in.Src, pf.info.Size())
+		if pf.err == nil && pf.sparse != nil {
+			pf.sha256 = pf.sparse.sum(pf.data)
+			return pf
+		}
+		size := pf.info.Size()
+		if !budget.tryAcquire(size) {
+			pf.stream = true
+			return pf
+		}
+		pf.finished = func() { budget.release(size) }
+	}
+	pf.data, pf.err = ioutil.ReadFile(in.Src)
+	if pf.err == nil && in.Stamp {
+		pf.data = stamp(pf.data, values)
+	}
+	if pf.err == nil {
+		pf.sha256 = sha256.Sum256(pf.data)
+	}
+	return pf
+}
+
+// prefetchFiles reads, stamps with values and hashes files on up to jobs
+// goroutines, sharing one prefetch budget between them. Results are
+// delivered in input order, and no more than jobs results are held ahead
+// of the consumer, which must finish each of them.
+func prefetchFiles(files []FileInput, values map[string]string, jobs int, done <-chan struct{}) <-chan chan *prefetchedFile {
+	if jobs < 1 {
+		jobs = 1
+	}
+	budget := newPrefetchBudget(filePrefetchBytes)
+	out := make(chan chan *prefetchedFile, jobs)
+	go func() {
+		defer close(out)
+		for _, in := range files {
+			ch := make(chan *prefetchedFile, 1)
+			select {
+			case out <- ch:
+			case <-done:
+				return
+			}
+			go func(in FileInput) {
+				ch <- readFileInput(in, values, budget)
+			}(in)
+		}
+	}()
+	return out
+}
+
+// tarEntry is a single decoded entry of an input tar. The consumer must
+// call finish once it is done with the entry.
+type tarEntry struct {
+	header *tar.Header
+	data   []byte
+	sha256 [sha256.Size]byte
+	err    error
+	// tar is the path of the input tar, and index the position of the
+	// entry in it.
+	tar   string
+	index int
+	// sparse, if not nil, describes the holes of an entry that was sparse
+	// in the input tar, whose data only holds the fragments.
+	sparse *sparseFile
+	// stream, if not nil, reads the contents of an entry too large to
+	// buffer from the input tar, in place of data and sha256. The tar isn't
+	// decoded further until the entry is finished.
+	stream io.Reader
+	// finished releases the entry's buffer or stream.
+	finished func()
+}
+
+// storedSize returns the bytes of data the entry takes in an archive.
+func (e *tarEntry) storedSize() int64 {
+	if e.sparse != nil {
+		return e.sparse.storedSize(e.data)
+	}
+	return e.header.Size
+}
+
+// finish releases the entry's data: the buffered contents count against
+// the prefetch budget until then, and a stream can't be read after.
+func (e *tarEntry) finish() {
+	if e.finished != nil {
+		e.finished()
+		e.finished = nil
+	}
+	e.stream = nil
+}
+
+// load reads the contents of a streamed entry into data, so that it can be
+// kept after the entry is finished.
+func (e *tarEntry) load() error {
+	if e.stream == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(e.stream)
+	if err != nil {
+		return err
+	}
+	e.data, e.sha256, e.stream = data, sha256.Sum256(data), nil
+	return nil
+}
+
+// hash reads a streamed entry to compute its sha256, without keeping its
+// contents.
+func (e *tarEntry) hash() error {
+	if e.stream == nil {
+		return nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, e.stream); err != nil {
+		return err
+	}
+	copy(e.sha256[:], h.Sum(nil))
+	e.stream = nil
+	return nil
+}
+
+// openTarEntry opens the contents of the entry at index in the tar at
+// path, to read an entry again once it has been finished.
+func openTarEntry(path string, index int) (io.Reader, io.Closer, error) {
+	tr, closer, err := openTar(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i <= index; i++ {
+		if _, err := tr.Next(); err != nil {
+			closer.Close()
+			return nil, nil, fmt.Errorf("reading entry %d of %s again: %v", index, path, err)
+		}
+	}
+	return tr, closer, nil
+}
+
+// openTar opens a possibly compressed tar, choosing the decompressor from
+// the file name.
+func openTar(path string) (*tar.Reader, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, &InputError{Path: path, Err: err}
+	}
+
+	var r io.Reader = bufio.NewReader(file)
+
+	switch {
+	case strings.HasSuffix(path, "gz"):
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			file.Close()
+			return nil, nil, &InputError{Path: path, Err: err}
+		}
+		r = gzr
+	case strings.HasSuffix(path, "bz2"):
+		r = bzip2.NewReader(r)
+	case strings.HasSuffix(path, "xz"):
+		file.Close()
+		return nil, nil, &InputError{Path: path, Err: errors.New("xz decompression is not supported yet")}
+	default:
+	}
+
+	return tar.NewReader(r), file, nil
+}
+
+// inputReader returns the errors reading r, from the input at path, as
+// InputErrors.
+type inputReader struct {
+	r    io.Reader
+	path string
+}
+
+func (ir inputReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &InputError{Path: ir.path, Err: err}
+	}
+	return n, err
+}
+
+// prefetchTar decodes the entries of a tar on its own goroutine, buffering
+// their contents while budget allows and streaming them otherwise. The
+// returned channel is closed after the last entry or after an entry
+// carrying an error.
+func prefetchTar(path string, budget *prefetchBudget, done <-chan struct{}) <-chan tarEntry {
+	out := make(chan tarEntry, tarEntryDepth)
+	go func() {
+		defer close(out)
+		send := func(e tarEntry) bool {
+			select {
+			case out <- e:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		tr, closer, err := openTar(path)
+		if err != nil {
+			send(tarEntry{err: err})
+			return
+		}
+		defer closer.Close()
+
+		for index := 0; ; index++ {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				send(tarEntry{err: &InputError{Path: path, Err: err}})
+				return
+			}
+			e := tarEntry{header: header, tar: path, index: index}
+
+			// Sparse entries are buffered whole, since the sparse map
+			// written before their data depends on all of it.
+			sparse := isSparseHeader(header)
+			if !sparse && !budget.tryAcquire(header.Size) {
+				finished := make(chan struct{})
+				e.stream = inputReader{r: tr, path: path}
+				e.finished = func() { close(finished) }
+				if !send(e) {
+					return
+				}
+				select {
+				case <-finished:
+				case <-done:
+					return
+				}
+				continue
+			}
+
+			var data []byte
+			if sparse {
+				header.Typeflag = tar.TypeReg
+				e.sparse, data, err = readSparseEntry(tr, header.Size)
+			} else {
+				data, err = ioutil.ReadAll(tr)
+			}
+			if err != nil {
+				send(tarEntry{err: &InputError{Path: path, Err: err}})
+				return
+			}
+			if e.sparse != nil {
+				e.sha256 = e.sparse.sum(data)
+			} else {
+				e.sha256 = sha256.Sum256(data)
+			}
+			e.data = data
+			if !sparse {
+				size := header.Size
+				e.finished = func() { budget.release(size) }
+			}
+			if !send(e) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// prefetchTars starts decoding tars ahead of the consumer, keeping about jobs
+// of them in flight and sharing one prefetch budget between them. Results
+// are delivered in input order.
+func prefetchTars(paths []string, jobs int, done <-chan struct{}) <-chan (<-chan tarEntry) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	budget := newPrefetchBudget(tarPrefetchBytes)
+	out := make(chan (<-chan tarEntry), jobs-1)
+	go func() {
+		defer close(out)
+		for _, path := range paths {
+			select {
+			case out <- prefetchTar(path, budget, done):
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrefetchFilesKeepsOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var inputs []FileInput
+	for i := 0; i < 50; i++ {
+		src := filepath.Join(dir, fmt.Sprintf("f%d", i))
+		if err := ioutil.WriteFile(src, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, FileInput{Src: src, Dest: fmt.Sprintf("d%d", i)})
+	}
+	inputs = append(inputs, FileInput{Src: filepath.Join(dir, "missing"), Dest: "missing"})
+
+	for _, jobs := range []int{0, 1, 4, 100} {
+		done := make(chan struct{})
+		i := 0
+		for ch := range prefetchFiles(inputs, nil, jobs, done) {
+			pf := <-ch
+			if pf.FileInput != inputs[i] {
+				t.Errorf("jobs=%d: result %d is %v; want %v", jobs, i, pf.FileInput, inputs[i])
+			}
+			if i < 50 && (pf.err != nil || string(pf.data) != pf.Src) {
+				t.Errorf("jobs=%d: result %d has data %q, err %v", jobs, i, pf.data, pf.err)
+			}
+			if i == 50 && pf.err == nil {
+				t.Errorf("jobs=%d: expected error for missing input", jobs)
+			}
+			i++
+		}
+		close(done)
+		if i != len(inputs) {
+			t.Errorf("jobs=%d: got %d results; want %d", jobs, i, len(inputs))
+		}
+	}
+}
+
+func TestPrefetchFilesStreamsOverBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var inputs []FileInput
+	for _, f := range []struct{ name, data string }{
+		{"small", "12345"},
+		{"big", strings.Repeat("x", 100)},
+		{"small2", "12345"},
+	} {
+		src := filepath.Join(dir, f.name)
+		if err := ioutil.WriteFile(src, []byte(f.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, FileInput{Src: src, Dest: f.name})
+	}
+
+	defer func(n int64) { filePrefetchBytes = n }(filePrefetchBytes)
+	filePrefetchBytes = 10
+	done := make(chan struct{})
+	defer close(done)
+	var (
+		got  []string
+		held []*prefetchedFile
+	)
+	// Results are held until all are read, so that the budget isn't
+	// released early.
+	for ch := range prefetchFiles(inputs, nil, 1, done) {
+		pf := <-ch
+		if pf.err != nil {
+			t.Fatal(pf.err)
+		}
+		got = append(got, fmt.Sprintf("%s stream=%v", pf.Dest, pf.stream))
+		held = append(held, pf)
+	}
+	for _, pf := range held {
+		pf.finish()
+	}
+	if want := []string{"small stream=false", "big stream=true", "small2 stream=false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q; want %q", got, want)
+	}
+}
+
+func TestAddFilesStreamed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var inputs []FileInput
+	for i, data := range []string{strings.Repeat("big", 1000), "small", strings.Repeat("big", 2000)} {
+		src := filepath.Join(dir, fmt.Sprintf("f%d", i))
+		if err := ioutil.WriteFile(src, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, FileInput{Src: src, Dest: fmt.Sprintf("d/f%d", i)})
+	}
+
+	build := func() []string {
+		var buf bytes.Buffer
+		tb, err := New(&buf, Options{Jobs: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tb.AddFiles(inputs); err != nil {
+			t.Fatal(err)
+		}
+		if err := tb.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return readTestTar(t, &buf)
+	}
+
+	want := build()
+	defer func(n int64) { filePrefetchBytes = n }(filePrefetchBytes)
+	filePrefetchBytes = 100
+	if got := build(); !reflect.DeepEqual(got, want) {
+		t.Errorf("streaming files changed the archive: got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPrefetchTarStreamsOverBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "small", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "big", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "small2", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"small": "12345", "big": strings.Repeat("x", 100), "small2": "12345"})
+
+	done := make(chan struct{})
+	defer close(done)
+	budget := newPrefetchBudget(10)
+	var got []string
+	for e := range prefetchTar(in, budget, done) {
+		if e.err != nil {
+			t.Fatal(e.err)
+		}
+		// Entries are buffered while they fit in what is left of the
+		// budget, and streamed otherwise.
+		got = append(got, fmt.Sprintf("%s stream=%v", e.header.Name, e.stream != nil))
+		if err := e.load(); err != nil {
+			t.Fatal(err)
+		}
+		if want := e.header.Size; int64(len(e.data)) != want {
+			t.Errorf("%s: got %d bytes; want %d", e.header.Name, len(e.data), want)
+		}
+		e.finish()
+	}
+	if want := []string{"small stream=false", "big stream=true", "small2 stream=false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %q; want %q", got, want)
+	}
+	if budget.left != 10 {
+		t.Errorf("budget has %d bytes left after all entries were finished; want 10", budget.left)
+	}
+}
+
+func TestAddTarsStreamed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("big", 1000)
+	a := writeTestTar(t, dir, "a.tar", []tar.Header{
+		{Name: "dup", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeLink, Linkname: "dup", Mode: 0644},
+		{Name: "a/big", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"dup": big, "a/big": big})
+	b := writeTestTar(t, dir, "b.tar", []tar.Header{
+		{Name: "b/big", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b/small", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"b/big": big, "b/small": "small"})
+
+	build := func() []string {
+		var buf bytes.Buffer
+		tb, err := New(&buf, Options{Jobs: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tb.AddFile(src, "dup"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tb.AddTars([]string{a, b}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tb.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return readTestTar(t, &buf)
+	}
+
+	want := build()
+	defer func(n int64) { tarPrefetchBytes = n }(tarPrefetchBytes)
+	tarPrefetchBytes = 100
+	got := build()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("streaming entries changed the archive: got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// The hardlink to the dropped duplicate is a copy of the entry it
+	// pointed to, read again from a.tar.
+	if len(got) < 2 || got[1] != "link 0 0644 0:0 "+big {
+		t.Errorf("got entries %.200q; want link to be a copy of a.tar's dup", got)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAddTarsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	big := strings.Repeat("big", 10000)
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "big", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"big": big})
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.tar")
+	if err := ioutil.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(n int64) { tarPrefetchBytes = n }(tarPrefetchBytes)
+	// A tar that can't be read is an input error, whether its entries are
+	// buffered or streamed.
+	for _, budget := range []int64{64 << 20, 100} {
+		tarPrefetchBytes = budget
+		b, err := New(ioutil.Discard, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.AddTars([]string{truncated})
+		var inputErr *InputError
+		if !errors.As(err, &inputErr) || inputErr.Path != truncated {
+			t.Errorf("budget %d: got error %v; want an InputError for %s", budget, err, truncated)
+		}
+	}
+
+	// Failing to write the archive is an output error.
+	b, err := New(failingWriter{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.AddTars([]string{in})
+	var outputErr *OutputError
+	if !errors.As(err, &outputErr) {
+		t.Errorf("got error %v writing to a failing output; want an OutputError", err)
+	}
+}
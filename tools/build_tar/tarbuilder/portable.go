@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"k8s.io/klog/v2"
+)
+
+// PortableCheck selects how names that can't be extracted on macOS or
+// Windows are reported when the archive is closed.
+type PortableCheck int
+
+const (
+	// PortableCheckOff skips the checks.
+	PortableCheckOff PortableCheck = iota
+	// PortableCheckWarn logs a warning for each problem found.
+	PortableCheckWarn
+	// PortableCheckError logs each problem and makes Close return an error.
+	PortableCheckError
+)
+
+// ParsePortableCheck parses "", "off", "warn" or "error".
+func ParsePortableCheck(s string) (PortableCheck, error) {
+	switch s {
+	case "", "off":
+		return PortableCheckOff, nil
+	case "warn":
+		return PortableCheckWarn, nil
+	case "error":
+		return PortableCheckError, nil
+	}
+	return PortableCheckOff, fmt.Errorf("unknown portable check %q, want off, warn or error", s)
+}
+
+// maxPortablePath is the longest path, in characters, that extracts
+// everywhere. Windows limits paths to 260 characters including the
+// extraction root.
+const maxPortablePath = 255
+
+// reservedNames are the device names Windows reserves in every directory,
+// with or without an extension.
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// reservedChars can't appear in Windows file names.
+const reservedChars = `<>:"\|?*`
+
+// checkPortableName records the problems with name, the cleaned path of an
+// entry being written, on case-insensitive filesystems and Windows.
+func (b *Builder) checkPortableName(name string) {
+	if b.portableCheck == PortableCheckOff {
+		return
+	}
+	if !utf8.ValidString(name) {
+		b.portableProblems = append(b.portableProblems, fmt.Sprintf("%q is not valid UTF-8", name))
+	} else if n := utf8.RuneCountInString(name); n > maxPortablePath {
+		b.portableProblems = append(b.portableProblems, fmt.Sprintf("%s is %d characters long, over %d", name, n, maxPortablePath))
+	}
+
+	// Parent directories are checked as they are written.
+	base := path.Base(name)
+	if stem := strings.ToLower(strings.SplitN(base, ".", 2)[0]); reservedNames[stem] {
+		b.portableProblems = append(b.portableProblems, fmt.Sprintf("%s uses the reserved name %s", name, base))
+	}
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return r < 0x20 || strings.ContainsRune(reservedChars, r)
+	}); i >= 0 {
+		b.portableProblems = append(b.portableProblems, fmt.Sprintf("%q contains the reserved character %q", name, base[i]))
+	} else if strings.HasSuffix(base, ".") || strings.HasSuffix(base, " ") {
+		b.portableProblems = append(b.portableProblems, fmt.Sprintf("%q ends with a dot or space", name))
+	}
+
+	folded := foldName(name)
+	if first, ok := b.portableNames[folded]; ok && first != name {
+		b.portableProblems = append(b.portableProblems, fmt.Sprintf("%s collides with %s on case-insensitive or normalizing filesystems", name, first))
+	} else if !ok {
+		b.portableNames[folded] = name
+	}
+}
+
+// foldName returns the form of name that names equivalent to it on
+// case-insensitive filesystems share. macOS also treats composed and
+// decomposed forms of the same characters, as in NFC and NFD, as the same
+// name, so names are compared in NFC with simple Unicode case folding.
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		// SimpleFold cycles through the runes that fold together; the
+		// smallest of them stands for all.
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, norm.NFC.String(name))
+}
+
+// checkPortable reports the problems found by checkPortableName according
+// to the builder's PortableCheck.
+func (b *Builder) checkPortable() error {
+	if b.portableCheck == PortableCheckOff {
+		return nil
+	}
+	for _, p := range b.portableProblems {
+		klog.Warningf("Unportable entry in archive: %s", p)
+	}
+	if b.portableCheck == PortableCheckError && len(b.portableProblems) > 0 {
+		return fmt.Errorf("found %d unportable entries in archive: %s", len(b.portableProblems), strings.Join(b.portableProblems, "; "))
+	}
+	return nil
+}
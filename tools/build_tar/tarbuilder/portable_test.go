@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPortableProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "docs/README", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "docs/readme", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "Docs/index", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dev/con.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dev/console", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dev/what?", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dev/trailing.", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dev/caf\xe9", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "i18n/caf\u00e9", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "i18n/cafe\u0301", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "i18n/\u03a3\u03b1", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "i18n/\u03c3\u03b1", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "i18n/\u03c2\u03b1", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "i18n/\u00c5ngstr\u00f6m", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "i18n/\u212bngstr\u00f6m", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "long/" + strings.Repeat("x", 251), Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "long/" + strings.Repeat("x", 250), Typeflag: tar.TypeReg, Mode: 0644},
+	}, nil)
+
+	for _, tc := range []struct {
+		check   PortableCheck
+		want    []string
+		wantErr bool
+	}{
+		{check: PortableCheckOff},
+		{
+			check: PortableCheckWarn,
+			want: []string{
+				"docs/readme collides with docs/README on case-insensitive or normalizing filesystems",
+				"Docs collides with docs on case-insensitive or normalizing filesystems",
+				"dev/con.txt uses the reserved name con.txt",
+				`"dev/what?" contains the reserved character '?'`,
+				`"dev/trailing." ends with a dot or space`,
+				`"dev/caf\xe9" is not valid UTF-8`,
+				// NFD of NFC, as written by macOS.
+				"i18n/cafe\u0301 collides with i18n/caf\u00e9 on case-insensitive or normalizing filesystems",
+				// Upper case, lower case and final sigma.
+				"i18n/\u03c3\u03b1 collides with i18n/\u03a3\u03b1 on case-insensitive or normalizing filesystems",
+				"i18n/\u03c2\u03b1 collides with i18n/\u03a3\u03b1 on case-insensitive or normalizing filesystems",
+				// The angstrom sign is canonically equivalent to \u00c5.
+				"i18n/\u212bngstr\u00f6m collides with i18n/\u00c5ngstr\u00f6m on case-insensitive or normalizing filesystems",
+				"long/" + strings.Repeat("x", 251) + " is 256 characters long, over 255",
+			},
+		},
+		{
+			check:   PortableCheckError,
+			wantErr: true,
+		},
+	} {
+		b, err := New(ioutil.Discard, Options{PortableCheck: tc.check})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddTar(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); (err != nil) != tc.wantErr {
+			t.Errorf("PortableCheck %d: Close() = %v; want error %v", tc.check, err, tc.wantErr)
+		}
+		if tc.want != nil && !reflect.DeepEqual(b.portableProblems, tc.want) {
+			t.Errorf("portableProblems =\n%s\nwant\n%s", strings.Join(b.portableProblems, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestParsePortableCheck(t *testing.T) {
+	for s, want := range map[string]PortableCheck{"": PortableCheckOff, "off": PortableCheckOff, "warn": PortableCheckWarn, "error": PortableCheckError} {
+		if got, err := ParsePortableCheck(s); err != nil || got != want {
+			t.Errorf("ParsePortableCheck(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParsePortableCheck("strict"); err == nil {
+		t.Errorf("ParsePortableCheck(%q) succeeded; want error", "strict")
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// cleanArchivePath cleans name relative to the archive root, dropping any
+// leading slashes. It returns an error if the result escapes the root.
+func cleanArchivePath(name string) (string, error) {
+	cleaned := filepath.Clean(strings.TrimLeft(name, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("path %q escapes the archive root", name)
+	}
+	return cleaned, nil
+}
+
+// checkNoSymlinkParent returns an error if any parent directory of name is a
+// symlink already written to the archive. Extracting such an entry would
+// write through the symlink, possibly outside the extraction root.
+func (b *Builder) checkNoSymlinkParent(name string) error {
+	for dir := filepath.Dir(name); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if _, ok := b.symlinks[dir]; ok {
+			return fmt.Errorf("path %q traverses symlink %q", name, dir)
+		}
+	}
+	return nil
+}
+
+// rejectUnsafe handles an unsafe entry: in strict mode it returns err,
+// otherwise it logs a warning and returns nil so that the entry is skipped.
+func (b *Builder) rejectUnsafe(err error) error {
+	if b.strictPaths {
+		return err
+	}
+	klog.Warningf("Skipping unsafe entry: %v", err)
+	return nil
+}
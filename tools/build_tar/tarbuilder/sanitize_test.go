@@ -0,0 +1,96 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCleanArchivePath(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "a/b", want: "a/b"},
+		{in: "/a//b/", want: "a/b"},
+		{in: "./a/../b", want: "b"},
+		{in: "/../a", wantErr: true},
+		{in: "../../etc/passwd", wantErr: true},
+		{in: "a/../../b", wantErr: true},
+		{in: "..", wantErr: true},
+		{in: "..a", want: "..a"},
+	} {
+		got, err := cleanArchivePath(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("cleanArchivePath(%q) = %q, %v; want %q, error %v", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestAddTarRejectsUnsafeEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "../../etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/etc", Mode: 0777},
+		{Name: "escape/shadow", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "/ok", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "abs", Typeflag: tar.TypeLink, Linkname: "/ok", Mode: 0644},
+		{Name: "up", Typeflag: tar.TypeLink, Linkname: "../ok", Mode: 0644},
+	}, nil)
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddTar(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(in, "escape/file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"escape 2 0777 0:0 -> /etc",
+		"ok 0 0644 0:0",
+		"abs 1 0644 0:0 -> ok",
+	}
+	if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	b, err = New(ioutil.Discard, Options{StrictPaths: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddTar(in); err == nil {
+		t.Errorf("AddTar succeeded in strict mode; want error")
+	}
+}
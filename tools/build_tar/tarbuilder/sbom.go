@@ -0,0 +1,174 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+)
+
+// SBOMPackage is a software package installed in the archive.
+type SBOMPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Files lists the archive paths of the package's files, if known.
+	Files []string `json:"files,omitempty"`
+}
+
+// ReadSBOMPackages reads a JSON list of SBOMPackages from path.
+func ReadSBOMPackages(path string) ([]SBOMPackage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var packages []SBOMPackage
+	if err := json.Unmarshal(data, &packages); err != nil {
+		return nil, fmt.Errorf("couldn't parse package list %s: %v", path, err)
+	}
+	return packages, nil
+}
+
+const noAssertion = "NOASSERTION"
+
+// The SPDX 2.3 JSON document types, limited to the fields build_tar fills.
+// See https://spdx.github.io/spdx-spec/v2.3/.
+type (
+	spdxDocument struct {
+		SPDXVersion       string             `json:"spdxVersion"`
+		DataLicense       string             `json:"dataLicense"`
+		SPDXID            string             `json:"SPDXID"`
+		Name              string             `json:"name"`
+		DocumentNamespace string             `json:"documentNamespace"`
+		CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+		Packages          []spdxPackage      `json:"packages"`
+		Files             []spdxFile         `json:"files,omitempty"`
+		Relationships     []spdxRelationship `json:"relationships"`
+	}
+	spdxCreationInfo struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	}
+	spdxPackage struct {
+		SPDXID           string `json:"SPDXID"`
+		Name             string `json:"name"`
+		VersionInfo      string `json:"versionInfo,omitempty"`
+		DownloadLocation string `json:"downloadLocation"`
+		FilesAnalyzed    bool   `json:"filesAnalyzed"`
+		LicenseConcluded string `json:"licenseConcluded"`
+		LicenseDeclared  string `json:"licenseDeclared"`
+		CopyrightText    string `json:"copyrightText"`
+	}
+	spdxFile struct {
+		SPDXID           string         `json:"SPDXID"`
+		FileName         string         `json:"fileName"`
+		Checksums        []spdxChecksum `json:"checksums"`
+		LicenseConcluded string         `json:"licenseConcluded"`
+		CopyrightText    string         `json:"copyrightText"`
+	}
+	spdxChecksum struct {
+		Algorithm     string `json:"algorithm"`
+		ChecksumValue string `json:"checksumValue"`
+	}
+	spdxRelationship struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	}
+)
+
+// WriteSBOM writes an SPDX 2.3 JSON document named name to w, describing
+// the archive as a package that contains every regular file written so far
+// and each of packages. Files listed by a package must be in the archive.
+//
+// The document is deterministic: its creation time is the archive's
+// ModTime and its namespace is derived from its contents.
+func (b *Builder) WriteSBOM(w io.Writer, name string, packages []SBOMPackage) error {
+	const rootID = "SPDXRef-Package-archive"
+	doc := spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        name,
+		CreationInfo: spdxCreationInfo{
+			Created:  b.meta.ModTime.UTC().Format("2006-01-02T15:04:05Z"),
+			Creators: []string{"Tool: build_tar"},
+		},
+		Packages: []spdxPackage{newSPDXPackage(rootID, name, "")},
+		Relationships: []spdxRelationship{
+			{"SPDXRef-DOCUMENT", "DESCRIBES", rootID},
+		},
+	}
+
+	// The namespace must be unique to this document, so it hashes
+	// everything the document says.
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", name)
+
+	fileIDs := map[string]string{}
+	for i, f := range b.contents {
+		id := fmt.Sprintf("SPDXRef-File-%d", i+1)
+		fileIDs[f.name] = id
+		doc.Files = append(doc.Files, spdxFile{
+			SPDXID:   id,
+			FileName: "./" + f.name,
+			Checksums: []spdxChecksum{
+				{Algorithm: "SHA256", ChecksumValue: fmt.Sprintf("%x", f.sha256)},
+			},
+			LicenseConcluded: noAssertion,
+			CopyrightText:    noAssertion,
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{rootID, "CONTAINS", id})
+		fmt.Fprintf(h, "file %s %x\n", f.name, f.sha256)
+	}
+
+	for i, p := range packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		doc.Packages = append(doc.Packages, newSPDXPackage(id, p.Name, p.Version))
+		doc.Relationships = append(doc.Relationships, spdxRelationship{rootID, "CONTAINS", id})
+		fmt.Fprintf(h, "package %s %s\n", p.Name, p.Version)
+		for _, file := range p.Files {
+			path, err := cleanArchivePath(file)
+			fileID, ok := fileIDs[path]
+			if err != nil || !ok {
+				return fmt.Errorf("package %s lists %s, which is not a file in the archive", p.Name, file)
+			}
+			doc.Relationships = append(doc.Relationships, spdxRelationship{id, "CONTAINS", fileID})
+			fmt.Fprintf(h, "package-file %s\n", path)
+		}
+	}
+	doc.DocumentNamespace = fmt.Sprintf("https://k8s.io/repo-infra/spdx/%s-%x", url.PathEscape(name), h.Sum(nil))
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func newSPDXPackage(id, name, version string) spdxPackage {
+	return spdxPackage{
+		SPDXID:           id,
+		Name:             name,
+		VersionInfo:      version,
+		DownloadLocation: noAssertion,
+		LicenseConcluded: noAssertion,
+		LicenseDeclared:  noAssertion,
+		CopyrightText:    noAssertion,
+	}
+}
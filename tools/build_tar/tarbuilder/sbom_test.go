@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteSBOM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "tool")
+	if err := ioutil.WriteFile(src, []byte("tool"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "usr/lib/libfoo.so", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/lib/libfoo.so.1", Typeflag: tar.TypeSymlink, Linkname: "libfoo.so", Mode: 0777},
+	}, map[string]string{"usr/lib/libfoo.so": "foo"})
+
+	build := func(packages []SBOMPackage) ([]byte, error) {
+		b, err := New(ioutil.Discard, Options{Meta: Metadata{ModTime: time.Unix(1600000000, 0)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddFile(src, "usr/bin/tool"); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddTar(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = b.WriteSBOM(&buf, "layer.tar", packages)
+		return buf.Bytes(), err
+	}
+
+	out, err := build([]SBOMPackage{{Name: "libfoo1", Version: "1.2-3", Files: []string{"/usr/lib/libfoo.so"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc spdxDocument
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SPDXVersion != "SPDX-2.3" || doc.CreationInfo.Created != "2020-09-13T12:26:40Z" {
+		t.Errorf("got version %q created %q; want SPDX-2.3 created 2020-09-13T12:26:40Z", doc.SPDXVersion, doc.CreationInfo.Created)
+	}
+
+	var files []string
+	for _, f := range doc.Files {
+		files = append(files, fmt.Sprintf("%s %s %s:%s", f.SPDXID, f.FileName, f.Checksums[0].Algorithm, f.Checksums[0].ChecksumValue))
+	}
+	wantFiles := []string{
+		fmt.Sprintf("SPDXRef-File-1 ./usr/bin/tool SHA256:%x", sha256.Sum256([]byte("tool"))),
+		fmt.Sprintf("SPDXRef-File-2 ./usr/lib/libfoo.so SHA256:%x", sha256.Sum256([]byte("foo"))),
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("got files %q; want %q", files, wantFiles)
+	}
+
+	var packages []string
+	for _, p := range doc.Packages {
+		packages = append(packages, p.SPDXID+" "+p.Name+" "+p.VersionInfo)
+	}
+	wantPackages := []string{
+		"SPDXRef-Package-archive layer.tar ",
+		"SPDXRef-Package-1 libfoo1 1.2-3",
+	}
+	if !reflect.DeepEqual(packages, wantPackages) {
+		t.Errorf("got packages %q; want %q", packages, wantPackages)
+	}
+
+	wantRelationships := []spdxRelationship{
+		{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Package-archive"},
+		{"SPDXRef-Package-archive", "CONTAINS", "SPDXRef-File-1"},
+		{"SPDXRef-Package-archive", "CONTAINS", "SPDXRef-File-2"},
+		{"SPDXRef-Package-archive", "CONTAINS", "SPDXRef-Package-1"},
+		{"SPDXRef-Package-1", "CONTAINS", "SPDXRef-File-2"},
+	}
+	if !reflect.DeepEqual(doc.Relationships, wantRelationships) {
+		t.Errorf("got relationships %+v; want %+v", doc.Relationships, wantRelationships)
+	}
+
+	again, err := build([]SBOMPackage{{Name: "libfoo1", Version: "1.2-3", Files: []string{"usr/lib/libfoo.so"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, again) {
+		t.Errorf("SBOM is not deterministic")
+	}
+
+	if _, err := build([]SBOMPackage{{Name: "libbar1", Files: []string{"usr/lib/libbar.so"}}}); err == nil {
+		t.Errorf("WriteSBOM() with a package file missing from the archive succeeded; want error")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+)
+
+// Signatures are detached: an output at path is signed in path.sig, and
+// the fingerprint of the signing key is written to path.fingerprint.
+//
+// Both key types sign the raw SHA-256 digest of the output, so that it can
+// be signed while it is written. For ECDSA keys this is the usual
+// ASN.1-encoded signature checked by `openssl dgst -sha256 -verify`; for
+// ed25519 keys the digest itself is the signed message.
+
+// LoadSigningKey reads an ed25519 or ECDSA private key from a PEM file, as
+// written by `openssl genpkey` or `openssl ecparam -genkey`.
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported key type %T, want ed25519 or ECDSA", path, key)
+}
+
+// LoadPublicKey reads an ed25519 or ECDSA public key from a PEM file.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s: no PUBLIC KEY PEM block found", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported key type %T, want ed25519 or ECDSA", path, key)
+}
+
+// Fingerprint returns the hex SHA-256 digest of the DER-encoded public key.
+func Fingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(der)), nil
+}
+
+// writeSignature signs digest, the SHA-256 digest of the output at path,
+// and writes the signature and key fingerprint next to it.
+func writeSignature(path string, signer crypto.Signer, digest []byte) error {
+	// ed25519 signs the message as given; ECDSA signs a digest made by
+	// the hash named in opts.
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		opts = crypto.Hash(0)
+	}
+	sig, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return fmt.Errorf("couldn't sign %s: %v", path, err)
+	}
+	fingerprint, err := Fingerprint(signer.Public())
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".sig", sig, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".fingerprint", []byte(fingerprint+"\n"), 0644)
+}
+
+// Verify checks the detached signature of the file at path against pub.
+func Verify(path string, pub crypto.PublicKey) error {
+	sig, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+
+	ok := false
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, digest, sig)
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err == nil && len(rest) == 0 {
+			ok = ecdsa.Verify(pub, digest, rs.R, rs.S)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	if !ok {
+		return fmt.Errorf("%s.sig is not a valid signature of %s", path, path)
+	}
+	return nil
+}
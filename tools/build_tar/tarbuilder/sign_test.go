@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, typ string
+		der       []byte
+		pub       crypto.PublicKey
+	}{
+		{"ed25519", "PRIVATE KEY", edDER, edKey.Public()},
+		{"ecdsa", "EC PRIVATE KEY", ecDER, ecKey.Public()},
+	} {
+		keyPath := filepath.Join(dir, tc.name+".pem")
+		writePEM(t, keyPath, tc.typ, tc.der)
+		signer, err := LoadSigningKey(keyPath)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		out := filepath.Join(dir, tc.name+".tar.gz")
+		b, err := CreateAll([]Output{{Path: out, Compression: "gz"}}, Options{Signer: signer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddLink("a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		pubDER, err := x509.MarshalPKIXPublicKey(tc.pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubPath := filepath.Join(dir, tc.name+".pub")
+		writePEM(t, pubPath, "PUBLIC KEY", pubDER)
+		pub, err := LoadPublicKey(pubPath)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err := Verify(out, pub); err != nil {
+			t.Errorf("%s: Verify() = %v", tc.name, err)
+		}
+
+		want, err := Fingerprint(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadFile(out + ".fingerprint"); err != nil || string(got) != want+"\n" {
+			t.Errorf("%s: fingerprint file = %q, %v; want %q", tc.name, got, err, want+"\n")
+		}
+
+		if err := ioutil.WriteFile(out, []byte("tampered"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(out, pub); err == nil {
+			t.Errorf("%s: Verify() of a modified archive succeeded; want error", tc.name)
+		}
+	}
+}
+
+func TestLoadSigningKeyRejectsOtherKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key.pem")
+	writePEM(t, path, "CERTIFICATE", []byte("not a key"))
+	if _, err := LoadSigningKey(path); err == nil {
+		t.Errorf("LoadSigningKey() of a certificate succeeded; want error")
+	}
+}
@@ -0,0 +1,375 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Sparse files are written in the GNU PAX 1.0 sparse format, which GNU tar,
+// bsdtar and archive/tar all read. archive/tar can't write it, so the
+// headers are encoded here and written around the tar.Writer.
+// See https://www.gnu.org/software/tar/manual/html_section/Sparse-Formats.html.
+
+const (
+	blockSize = 512
+	// paxGNUSparse is the prefix of the PAX records describing sparse files.
+	paxGNUSparse = "GNU.sparse."
+)
+
+// sparseFragment is a region of a sparse file holding data.
+type sparseFragment struct {
+	offset, length int64
+}
+
+// sparseFile describes a file of size bytes that only holds data in its
+// fragments; the rest are holes read as zeros. Its data is stored as the
+// concatenation of the fragments.
+type sparseFile struct {
+	size      int64
+	fragments []sparseFragment
+}
+
+// expand returns the full contents of the file stored as data.
+func (sf *sparseFile) expand(data []byte) []byte {
+	full := make([]byte, sf.size)
+	for _, frag := range sf.fragments {
+		copy(full[frag.offset:frag.offset+frag.length], data)
+		data = data[frag.length:]
+	}
+	return full
+}
+
+// sum returns the sha256 digest of the full contents of the file stored as
+// data, without expanding it.
+func (sf *sparseFile) sum(data []byte) [sha256.Size]byte {
+	h := sha256.New()
+	var pos int64
+	for _, frag := range sf.fragments {
+		writeZeros(h, frag.offset-pos)
+		h.Write(data[:frag.length])
+		data = data[frag.length:]
+		pos = frag.offset + frag.length
+	}
+	writeZeros(h, sf.size-pos)
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+var zeros [32 << 10]byte
+
+func writeZeros(h hash.Hash, n int64) {
+	for n > 0 {
+		chunk := int64(len(zeros))
+		if chunk > n {
+			chunk = n
+		}
+		h.Write(zeros[:chunk])
+		n -= chunk
+	}
+}
+
+// seekDataHole returns the lseek whence values of SEEK_DATA and SEEK_HOLE,
+// and whether the platform has them.
+func seekDataHole() (int, int, bool) {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "illumos", "solaris":
+		return 3, 4, true
+	case "darwin":
+		return 4, 3, true
+	}
+	return 0, 0, false
+}
+
+// readSparseFile returns the data fragments of the file of size bytes at
+// path, found with SEEK_DATA and SEEK_HOLE, and their contents. It returns
+// a nil sparseFile if the file has no holes or holes can't be found.
+func readSparseFile(path string, size int64) (*sparseFile, []byte, error) {
+	seekData, seekHole, ok := seekDataHole()
+	if !ok || size == 0 {
+		return nil, nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	sf := &sparseFile{size: size}
+	var stored int64
+	for pos := int64(0); pos < size; {
+		start, err := f.Seek(pos, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// No data after pos.
+			break
+		}
+		if err != nil {
+			return nil, nil, nil
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil, nil, nil
+		}
+		if end > size {
+			end = size
+		}
+		if end <= start {
+			break
+		}
+		sf.fragments = append(sf.fragments, sparseFragment{start, end - start})
+		stored += end - start
+		pos = end
+	}
+	if stored == size {
+		return nil, nil, nil
+	}
+
+	data := make([]byte, 0, stored)
+	for _, frag := range sf.fragments {
+		buf := make([]byte, frag.length)
+		if _, err := f.ReadAt(buf, frag.offset); err != nil {
+			return nil, nil, err
+		}
+		data = append(data, buf...)
+	}
+	return sf, data, nil
+}
+
+// isSparseHeader reports whether an entry read by archive/tar was stored
+// sparse. The reader expands such entries, but keeps their old GNU type or
+// PAX records.
+func isSparseHeader(h *tar.Header) bool {
+	if h.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range h.PAXRecords {
+		if strings.HasPrefix(k, paxGNUSparse) {
+			return true
+		}
+	}
+	return false
+}
+
+// readSparseEntry reads size bytes of an expanded sparse entry from r,
+// treating every block of zeros as a hole. It returns a nil sparseFile if
+// there are no holes.
+func readSparseEntry(r io.Reader, size int64) (*sparseFile, []byte, error) {
+	sf := &sparseFile{size: size}
+	var data []byte
+	buf := make([]byte, blockSize)
+	for pos := int64(0); pos < size; pos += blockSize {
+		n := size - pos
+		if n > blockSize {
+			n = blockSize
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return nil, nil, err
+		}
+		if bytes.Equal(buf[:n], zeros[:n]) {
+			continue
+		}
+		if last := len(sf.fragments) - 1; last >= 0 && sf.fragments[last].offset+sf.fragments[last].length == pos {
+			sf.fragments[last].length += n
+		} else {
+			sf.fragments = append(sf.fragments, sparseFragment{pos, n})
+		}
+		data = append(data, buf[:n]...)
+	}
+	if int64(len(data)) == size {
+		return nil, data, nil
+	}
+	return sf, data, nil
+}
+
+// sparseMap returns the sparse map stored before the data of sf, padded to
+// whole blocks. It lists the fragments, ending with an empty one at the end
+// of the file if it ends in a hole, as GNU tar does.
+func (sf *sparseFile) sparseMap() []byte {
+	fragments := sf.fragments
+	if n := len(fragments); n == 0 || fragments[n-1].offset+fragments[n-1].length < sf.size {
+		fragments = append(fragments[:n:n], sparseFragment{sf.size, 0})
+	}
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(fragments))
+	for _, frag := range fragments {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", frag.offset, frag.length)
+	}
+	sparseMap.Write(zeros[:padding(int64(sparseMap.Len()))])
+	return sparseMap.Bytes()
+}
+
+// storedSize bounds the bytes writeSparse writes for sf with data after
+// the header of the file: the sparse map, the data, and a block of PAX
+// records.
+func (sf *sparseFile) storedSize(data []byte) int64 {
+	return int64(len(sf.sparseMap())+len(data)) + blockSize
+}
+
+// writeSparse writes a regular file header, with Size set to the full size
+// of the file, followed by the fragments of sf stored in data.
+func (b *Builder) writeSparse(header *tar.Header, sf *sparseFile, data []byte, sum [sha256.Size]byte) error {
+	sparseMap := sf.sparseMap()
+
+	// Let archive/tar encode the ustar header, then add the sparse records
+	// to any PAX records it needed.
+	dir, file := path.Split(header.Name)
+	stored := *header
+	stored.Name = path.Join(dir, "GNUSparseFile.0", file)
+	stored.Typeflag = tar.TypeReg
+	stored.Size = int64(len(sparseMap) + len(data))
+	stored.Format = tar.FormatPAX
+	stored.PAXRecords = nil
+	for k, v := range header.PAXRecords {
+		if !strings.HasPrefix(k, paxGNUSparse) {
+			if stored.PAXRecords == nil {
+				stored.PAXRecords = map[string]string{}
+			}
+			stored.PAXRecords[k] = v
+		}
+	}
+	var hdrs bytes.Buffer
+	if err := tar.NewWriter(&hdrs).WriteHeader(&stored); err != nil {
+		return err
+	}
+	records := map[string]string{}
+	if raw := hdrs.Bytes(); len(raw) > blockSize && raw[156] == tar.TypeXHeader {
+		n, err := strconv.ParseInt(strings.Trim(string(raw[124:136]), " \x00"), 8, 64)
+		if err != nil {
+			return fmt.Errorf("couldn't encode sparse header for %s: %v", header.Name, err)
+		}
+		if records, err = parsePAXRecords(raw[blockSize : blockSize+n]); err != nil {
+			return fmt.Errorf("couldn't encode sparse header for %s: %v", header.Name, err)
+		}
+	}
+	records[paxGNUSparse+"major"] = "1"
+	records[paxGNUSparse+"minor"] = "0"
+	records[paxGNUSparse+"name"] = header.Name
+	records[paxGNUSparse+"realsize"] = strconv.FormatInt(sf.size, 10)
+
+	// Leave a block for the sparse PAX records too.
+	if err := b.reserveVolume(header, stored.Size+blockSize); err != nil {
+		return err
+	}
+	// Finish the previous entry before writing around the tar.Writer.
+	if err := b.tw.Flush(); err != nil {
+		return err
+	}
+	ustar := hdrs.Bytes()[hdrs.Len()-blockSize:]
+	paxData := formatPAXRecords(records)
+	paxHeader := paxHeaderBlock(path.Join(dir, "PaxHeaders.0", file), int64(len(paxData)), header.ModTime.Unix())
+	for _, p := range [][]byte{
+		paxHeader, paxData, zeros[:padding(int64(len(paxData)))],
+		ustar, sparseMap, data, zeros[:padding(stored.Size)],
+	} {
+		if _, err := b.out.Write(p); err != nil {
+			return err
+		}
+	}
+
+	b.recordHeader(header)
+	b.recordData(header, len(data), sum)
+	return nil
+}
+
+// padding returns the number of bytes padding n bytes to a whole block.
+func padding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
+
+// formatPAXRecords encodes PAX records, sorted by key. Each record is
+// "<length> <key>=<value>\n", where the length includes itself.
+func formatPAXRecords(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		rec := " " + k + "=" + records[k] + "\n"
+		n := len(rec) + len(strconv.Itoa(len(rec)))
+		if len(strconv.Itoa(n)) > len(strconv.Itoa(len(rec))) {
+			n++
+		}
+		fmt.Fprintf(&buf, "%d%s", n, rec)
+	}
+	return buf.Bytes()
+}
+
+// parsePAXRecords decodes the records of a PAX header.
+func parsePAXRecords(data []byte) (map[string]string, error) {
+	records := map[string]string{}
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 0 {
+			return nil, fmt.Errorf("invalid PAX record %q", data)
+		}
+		n, err := strconv.Atoi(string(data[:sp]))
+		if err != nil || n <= sp || n > len(data) || data[n-1] != '\n' {
+			return nil, fmt.Errorf("invalid PAX record %q", data)
+		}
+		kv := strings.SplitN(string(data[sp+1:n-1]), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid PAX record %q", data[:n])
+		}
+		records[kv[0]] = kv[1]
+		data = data[n:]
+	}
+	return records, nil
+}
+
+// paxHeaderBlock returns the ustar header of a PAX extended header of size
+// bytes, named like archive/tar names them.
+func paxHeaderBlock(name string, size, mtime int64) []byte {
+	blk := make([]byte, blockSize)
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	copy(blk[0:100], name)
+	copy(blk[100:108], "0000000\x00")
+	copy(blk[108:116], "0000000\x00")
+	copy(blk[116:124], "0000000\x00")
+	copy(blk[124:136], fmt.Sprintf("%011o\x00", size))
+	if mtime < 0 {
+		mtime = 0
+	}
+	copy(blk[136:148], fmt.Sprintf("%011o\x00", mtime))
+	blk[156] = tar.TypeXHeader
+	copy(blk[257:265], "ustar\x0000")
+
+	copy(blk[148:156], "        ")
+	var chksum int64
+	for _, c := range blk {
+		chksum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", chksum))
+	return blk
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPAXRecords(t *testing.T) {
+	records := map[string]string{
+		"a":                strings.Repeat("x", 4),
+		"b":                strings.Repeat("x", 5),
+		"c":                strings.Repeat("x", 94),
+		"d":                strings.Repeat("x", 95),
+		"GNU.sparse.major": "1",
+	}
+	data := formatPAXRecords(records)
+	if !bytes.HasPrefix(data, []byte("22 GNU.sparse.major=1\n9 a=xxxx\n11 b=xxxxx\n101 c=")) || !bytes.Contains(data, []byte("\n102 d=")) {
+		t.Errorf("formatPAXRecords() = %q", data)
+	}
+	got, err := parsePAXRecords(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("parsePAXRecords() = %v; want %v", got, records)
+	}
+}
+
+func TestReadSparseEntry(t *testing.T) {
+	data := make([]byte, 3000)
+	copy(data[600:], "data")
+	copy(data[1100:], "more")
+
+	sf, stored, err := readSparseEntry(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sparseFragment{{512, 1024}}
+	if sf == nil || !reflect.DeepEqual(sf.fragments, want) {
+		t.Fatalf("readSparseEntry() = %+v; want fragments %v", sf, want)
+	}
+	if !bytes.Equal(sf.expand(stored), data) {
+		t.Errorf("expanded data differs from input")
+	}
+
+	if sf, _, err := readSparseEntry(strings.NewReader("no holes"), 8); sf != nil || err != nil {
+		t.Errorf("readSparseEntry() of a dense entry = %+v, %v; want nil", sf, err)
+	}
+}
+
+// readSparseTar returns the expanded contents of each regular file in the
+// tar at path, and whether it was stored sparse.
+func readSparseTar(t *testing.T, path string) (map[string][]byte, map[string]bool) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	contents := map[string][]byte{}
+	sparse := map[string]bool{}
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return contents, sparse
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if contents[h.Name], err = ioutil.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+		sparse[h.Name] = h.PAXRecords["GNU.sparse.major"] == "1"
+	}
+}
+
+func TestSparseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const size = 8 << 20
+	src := filepath.Join(dir, "disk.img")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("boot"), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if sf, _, err := readSparseFile(src, size); err != nil || sf == nil {
+		t.Skipf("no holes found in %s (%v); the filesystem may not support them", src, err)
+	}
+	want := make([]byte, size)
+	copy(want[1<<20:], "boot")
+
+	small := filepath.Join(dir, "small")
+	if err := ioutil.WriteFile(small, []byte("small"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.tar")
+	b, err := Create(out, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFiles([]FileInput{{Src: src, Dest: "images/disk.img"}, {Src: small, Dest: "small"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := filepath.Join(dir, "merged.tar")
+	mb, err := Create(merged, Options{Directory: "opt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.AddTar(out); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, prefix := range map[string]string{out: "", merged: "opt/"} {
+		contents, sparse := readSparseTar(t, path)
+		if !bytes.Equal(contents[prefix+"images/disk.img"], want) {
+			t.Errorf("%s: disk.img has the wrong contents", path)
+		}
+		if string(contents[prefix+"small"]) != "small" {
+			t.Errorf("%s: small = %q; want %q", path, contents[prefix+"small"], "small")
+		}
+		if want := map[string]bool{prefix + "images/disk.img": true, prefix + "small": false}; !reflect.DeepEqual(sparse, want) {
+			t.Errorf("%s: got sparse entries %v; want %v", path, sparse, want)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() > 64<<10 {
+			t.Errorf("%s: got size %v, %v; want a sparse archive", path, info.Size(), err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// Whiteout markers of OCI and docker image layers. A file named
+// .wh.<name> deletes <name> from lower layers, and a file named
+// .wh..wh..opq deletes every lower-layer entry of its directory.
+// See https://github.com/opencontainers/image-spec/blob/master/layer.md.
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// squashedLayers holds the entries left after stacking layers, keyed on
+// their cleaned path.
+type squashedLayers struct {
+	entries map[string]tarEntry
+}
+
+// removeTree removes name and everything below it.
+func (s *squashedLayers) removeTree(name string) {
+	prefix := name + "/"
+	s.remove(func(n string) bool {
+		return n == name || strings.HasPrefix(n, prefix)
+	})
+}
+
+// removeChildren removes everything below dir.
+func (s *squashedLayers) removeChildren(dir string) {
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+	s.remove(func(n string) bool {
+		return strings.HasPrefix(n, prefix) && n != dir
+	})
+}
+
+// remove removes the entries whose names match, keeping the hardlinks to
+// them that remain.
+func (s *squashedLayers) remove(match func(name string) bool) {
+	for name := range s.entries {
+		if match(name) {
+			s.detachLinks(name, match)
+		}
+	}
+	for name := range s.entries {
+		if match(name) {
+			delete(s.entries, name)
+		}
+	}
+}
+
+// detachLinks keeps the hardlinks to name, other than those gone too,
+// linked to its current contents before the entry is removed or replaced:
+// the first of them in path order becomes a copy of the entry, and the
+// rest link to the copy.
+func (s *squashedLayers) detachLinks(name string, gone func(name string) bool) {
+	target, ok := s.entries[name]
+	if !ok {
+		return
+	}
+	var links []string
+	for link, e := range s.entries {
+		if e.header.Typeflag == tar.TypeLink && !gone(link) && squashKey(e.header.Linkname) == name {
+			links = append(links, link)
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	sort.Strings(links)
+	klog.V(2).Infof("Writing hardlink %s as a copy of %s, which a later layer removes", links[0], name)
+	first := s.entries[links[0]]
+	header := *first.header
+	copyEntry(&header, &first, target)
+	first.header = &header
+	s.entries[links[0]] = first
+	for _, link := range links[1:] {
+		e := s.entries[link]
+		header := *e.header
+		header.Linkname = links[0]
+		e.header = &header
+		s.entries[link] = e
+	}
+}
+
+// addLayer stacks a layer on the entries so far. Whiteouts only apply to
+// lower layers, so they are all processed before the layer's own entries.
+func (s *squashedLayers) addLayer(layer []tarEntry) {
+	for _, e := range layer {
+		name := squashKey(e.header.Name)
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+		switch {
+		case base == opaqueWhiteout:
+			s.removeChildren(dir)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			s.removeTree(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		}
+	}
+	for _, e := range layer {
+		name := squashKey(e.header.Name)
+		if strings.HasPrefix(path.Base(name), whiteoutPrefix) {
+			continue
+		}
+		// Replacing a directory with anything else drops its contents, as
+		// extracting the layer would.
+		if old, ok := s.entries[name]; ok && old.header.Typeflag == tar.TypeDir && e.header.Typeflag != tar.TypeDir {
+			s.removeChildren(name)
+		}
+		s.detachLinks(name, func(n string) bool { return n == name })
+		s.entries[name] = e
+	}
+}
+
+// sorted returns the entries in path order, each directory directly
+// followed by its contents, except for hardlinks. Those follow in a second
+// pass, each after its target, as extracting a hardlink needs its target
+// to exist.
+func (s *squashedLayers) sorted() []tarEntry {
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sortKey := func(name string) string {
+		return strings.Replace(name, "/", "\x00", -1)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return sortKey(names[i]) < sortKey(names[j])
+	})
+	entries := make([]tarEntry, 0, len(names))
+	written := map[string]bool{}
+	var links []string
+	for _, name := range names {
+		if s.entries[name].header.Typeflag == tar.TypeLink {
+			links = append(links, name)
+			continue
+		}
+		entries = append(entries, s.entries[name])
+		written[name] = true
+	}
+	// Hardlinks may point to other hardlinks, so each pass writes those
+	// whose target is written or isn't part of the squashed layers.
+	for len(links) > 0 {
+		var pending []string
+		for _, name := range links {
+			target := squashKey(s.entries[name].header.Linkname)
+			if _, ok := s.entries[target]; ok && !written[target] {
+				pending = append(pending, name)
+				continue
+			}
+			entries = append(entries, s.entries[name])
+			written[name] = true
+		}
+		if len(pending) == len(links) {
+			// A cycle of hardlinks can't be ordered; leave it to
+			// --check_links.
+			for _, name := range pending {
+				entries = append(entries, s.entries[name])
+			}
+			break
+		}
+		links = pending
+	}
+	return entries
+}
+
+// squashKey is the path of an entry for squashing. Unsafe paths are kept
+// as they are, to be rejected when the result is written.
+func squashKey(name string) string {
+	if cleaned, err := cleanArchivePath(name); err == nil {
+		return cleaned
+	}
+	return name
+}
+
+// SquashTars stacks the tars at paths as image layers, from lowest to
+// highest, and adds the resulting filesystem under the archive's
+// directory in path order. Later layers override earlier ones, and
+// whiteout and opaque markers delete the entries of lower layers.
+//
+// Unlike AddTars, every layer is held in memory until all are read.
+func (b *Builder) SquashTars(paths []string) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	s := &squashedLayers{entries: map[string]tarEntry{}}
+	for entries := range prefetchTars(paths, b.jobs, done) {
+		var layer []tarEntry
+		for e := range entries {
+			if e.err != nil {
+				return e.err
+			}
+			err := e.load()
+			e.finish()
+			if err != nil {
+				return err
+			}
+			layer = append(layer, e)
+		}
+		s.addLayer(layer)
+	}
+
+	sorted := s.sorted()
+	klog.V(2).Infof("Squashed %d layers into %d entries", len(paths), len(sorted))
+	entries := make(chan tarEntry, len(sorted))
+	for _, e := range sorted {
+		entries <- e
+	}
+	close(entries)
+	return b.addTarEntries(entries, strings.TrimLeft(b.directory, "/"), nil)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSquashTars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := writeTestTar(t, dir, "base.tar", []tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "usr/lib/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "usr/lib/old1", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/lib/old2", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/removed", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "opt/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opt/app/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opt/app/data", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "var/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "var/cache/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "var/cache/junk", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/config": "base", "usr/lib/old1": "1", "usr/lib/old2": "2"})
+	overlay := writeTestTar(t, dir, "overlay.tar", []tar.Header{
+		{Name: "etc/config", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "etc/.wh.removed", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/lib/new", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/lib/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "opt/app", Typeflag: tar.TypeSymlink, Linkname: "/usr/lib", Mode: 0777},
+		{Name: "var/.wh.cache", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/config": "overlay", "usr/lib/new": "new"})
+	top := writeTestTar(t, dir, "top.tar", []tar.Header{
+		{Name: "etc/removed", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/removed": "back"})
+
+	var buf bytes.Buffer
+	b, err := New(&buf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SquashTars([]string{base, overlay, top}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"etc/ 5 0755 0:0",
+		"etc/config 0 0600 0:0 overlay",
+		"etc/removed 0 0644 0:0 back",
+		"opt/ 5 0755 0:0",
+		"opt/app 2 0777 0:0 -> /usr/lib",
+		"usr/ 5 0755 0:0",
+		"usr/lib/ 5 0755 0:0",
+		"usr/lib/new 0 0644 0:0 new",
+		"var/ 5 0755 0:0",
+	}
+	if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSquashTarsHardlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Sorting by path puts both hardlinks before their targets.
+	layer := writeTestTar(t, dir, "layer.tar", []tar.Header{
+		{Name: "a/z", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "a/m", Typeflag: tar.TypeLink, Linkname: "a/z", Mode: 0644},
+		{Name: "a/b", Typeflag: tar.TypeLink, Linkname: "a/m", Mode: 0644},
+	}, map[string]string{"a/z": "data"})
+
+	out := filepath.Join(dir, "out.tar")
+	b, err := Create(out, Options{LinkCheck: LinkCheckError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SquashTars([]string{layer}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := []string{
+		"a/ 5 0755 0:0",
+		"a/z 0 0644 0:0 data",
+		"a/m 1 0644 0:0 -> a/z",
+		"a/b 1 0644 0:0 -> a/m",
+	}
+	if got := readTestTar(t, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	extracted := filepath.Join(dir, "extracted")
+	if err := os.Mkdir(extracted, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command("tar", "-xf", out, "-C", extracted).CombinedOutput(); err != nil {
+		t.Fatalf("extracting the squashed tar: %v\n%s", err, output)
+	}
+	for _, name := range []string{"a/z", "a/m", "a/b"} {
+		if data, err := ioutil.ReadFile(filepath.Join(extracted, name)); err != nil || string(data) != "data" {
+			t.Errorf("extracted %s = %q, %v; want %q", name, data, err, "data")
+		}
+	}
+}
+
+func TestSquashTarsRemovedLinkTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lower := writeTestTar(t, dir, "lower.tar", []tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b", Typeflag: tar.TypeLink, Linkname: "a", Mode: 0644},
+		{Name: "b2", Typeflag: tar.TypeLink, Linkname: "a", Mode: 0644},
+		{Name: "c", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "d", Typeflag: tar.TypeLink, Linkname: "c", Mode: 0644},
+	}, map[string]string{"a": "removed", "c": "old"})
+	// The upper layer removes a and replaces c.
+	upper := writeTestTar(t, dir, "upper.tar", []tar.Header{
+		{Name: ".wh.a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "c", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"c": "new"})
+
+	out := filepath.Join(dir, "out.tar")
+	b, err := Create(out, Options{LinkCheck: LinkCheckError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SquashTars([]string{lower, upper}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The first remaining hardlink to each target keeps its contents, and
+	// the others link to it.
+	want := []string{
+		"b 0 0644 0:0 removed",
+		"c 0 0644 0:0 new",
+		"d 0 0644 0:0 old",
+		"b2 1 0644 0:0 -> b",
+	}
+	if got := readTestTar(t, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	extracted := filepath.Join(dir, "extracted")
+	if err := os.Mkdir(extracted, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command("tar", "-xf", out, "-C", extracted).CombinedOutput(); err != nil {
+		t.Fatalf("extracting the squashed tar: %v\n%s", err, output)
+	}
+	for name, want := range map[string]string{"b": "removed", "b2": "removed", "c": "new", "d": "old"} {
+		if data, err := ioutil.ReadFile(filepath.Join(extracted, name)); err != nil || string(data) != want {
+			t.Errorf("extracted %s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+}
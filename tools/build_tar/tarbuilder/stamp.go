@@ -0,0 +1,69 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// placeholderRE matches a {KEY} placeholder for a workspace status value.
+var placeholderRE = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// StampValues reads Bazel workspace status files, such as
+// bazel-out/stable-status.txt and volatile-status.txt. Each line is a key,
+// a space and a value. Values from later files take precedence.
+func StampValues(paths []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			parts := strings.SplitN(s.Text(), " ", 2)
+			if parts[0] == "" {
+				continue
+			}
+			if len(parts) == 1 {
+				parts = append(parts, "")
+			}
+			values[parts[0]] = parts[1]
+		}
+		err = s.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// stamp replaces the {KEY} placeholders in data that have a value in
+// values. Other placeholders are left as they are, so that an unstamped
+// build always produces the same output.
+func stamp(data []byte, values map[string]string) []byte {
+	return placeholderRE.ReplaceAllFunc(data, func(m []byte) []byte {
+		if v, ok := values[string(m[1:len(m)-1])]; ok {
+			return []byte(v)
+		}
+		return m
+	})
+}
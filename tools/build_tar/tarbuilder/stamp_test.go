@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStampValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stable := filepath.Join(dir, "stable-status.txt")
+	volatile := filepath.Join(dir, "volatile-status.txt")
+	if err := ioutil.WriteFile(stable, []byte("STABLE_BUILD_GIT_COMMIT abc123\nSTABLE_VERSION v1.2.3 beta\nBUILD_EMPTY\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(volatile, []byte("BUILD_TIMESTAMP 1600000000\nSTABLE_VERSION v1.2.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := StampValues([]string{stable, volatile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"STABLE_BUILD_GIT_COMMIT": "abc123",
+		"STABLE_VERSION":          "v1.2.4",
+		"BUILD_EMPTY":             "",
+		"BUILD_TIMESTAMP":         "1600000000",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("StampValues() = %v; want %v", values, want)
+	}
+
+	if _, err := StampValues([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("StampValues() of a missing file succeeded; want error")
+	}
+}
+
+func TestStampedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "version")
+	if err := ioutil.WriteFile(src, []byte("commit={STABLE_BUILD_GIT_COMMIT} time={BUILD_TIMESTAMP} {not a key}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		stamp map[string]string
+		want  string
+	}{
+		{nil, "commit={STABLE_BUILD_GIT_COMMIT} time={BUILD_TIMESTAMP} {not a key}\n"},
+		{map[string]string{"STABLE_BUILD_GIT_COMMIT": "abc123"}, "commit=abc123 time={BUILD_TIMESTAMP} {not a key}\n"},
+	} {
+		var buf bytes.Buffer
+		b, err := New(&buf, Options{StampValues: tc.stamp})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddFiles([]FileInput{{Src: src, Dest: "raw"}, {Src: src, Dest: "version", Stamp: true}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"raw 0 0644 0:0 commit={STABLE_BUILD_GIT_COMMIT} time={BUILD_TIMESTAMP} {not a key}\n",
+			"version 0 0644 0:0 " + tc.want,
+		}
+		if got := readTestTar(t, &buf); !reflect.DeepEqual(got, want) {
+			t.Errorf("stamp %v: got %q; want %q", tc.stamp, got, want)
+		}
+	}
+}
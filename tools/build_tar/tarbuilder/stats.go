@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"crypto/sha256"
+	"io"
+	"sort"
+)
+
+// Stats summarizes the archive written by a Builder.
+type Stats struct {
+	// Entries counts the entries of each type, such as "file" or "dir".
+	Entries map[string]int `json:"entries"`
+	// UncompressedBytes is the size of the tar stream, and ContentBytes the
+	// total size of the entries' contents.
+	UncompressedBytes int64 `json:"uncompressed_bytes"`
+	ContentBytes      int64 `json:"content_bytes"`
+	// Outputs are the sizes of the output files, as compressed.
+	Outputs []OutputStats `json:"outputs,omitempty"`
+	// LargestFiles are the largest regular files, largest first.
+	LargestFiles []FileStats `json:"largest_files"`
+	// Sources are the inputs the entries came from, in the order they were
+	// first added.
+	Sources []*SourceStats `json:"sources"`
+}
+
+// OutputStats is the size of an output file.
+type OutputStats struct {
+	Path        string `json:"path"`
+	Compression string `json:"compression"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// FileStats is the size of a regular file in the archive.
+type FileStats struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	Source string `json:"source"`
+}
+
+// SourceStats is what an input contributed to the archive. Kind is one of
+// "file", "tar", "dir", "link" or "image"; Path is set for tars.
+type SourceStats struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path,omitempty"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+func (s *SourceStats) String() string {
+	if s.Path == "" {
+		return s.Kind
+	}
+	return s.Kind + ":" + s.Path
+}
+
+// writtenFile is a regular file written to the archive.
+type writtenFile struct {
+	name   string
+	size   int64
+	sha256 [sha256.Size]byte
+	source *SourceStats
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type outputCounter struct {
+	Output
+	counter *countingWriter
+}
+
+// buildStats accumulates Stats as entries are written.
+type buildStats struct {
+	entries      map[string]int
+	contentBytes int64
+	tarBytes     *countingWriter
+	outputs      []outputCounter
+
+	sources []*SourceStats
+	current *SourceStats
+}
+
+func newBuildStats(tarBytes *countingWriter) buildStats {
+	return buildStats{
+		entries:  map[string]int{},
+		tarBytes: tarBytes,
+	}
+}
+
+// setSource attributes the entries written next to the given input.
+func (s *buildStats) setSource(kind, path string) {
+	if s.current != nil && s.current.Kind == kind && s.current.Path == path {
+		return
+	}
+	for _, src := range s.sources {
+		if src.Kind == kind && src.Path == path {
+			s.current = src
+			return
+		}
+	}
+	s.current = &SourceStats{Kind: kind, Path: path}
+	s.sources = append(s.sources, s.current)
+}
+
+func (s *buildStats) addEntry(typeflag byte) {
+	s.entries[typeflagName(typeflag)]++
+	if s.current != nil {
+		s.current.Entries++
+	}
+}
+
+func (s *buildStats) addData(n int) {
+	s.contentBytes += int64(n)
+	if s.current != nil {
+		s.current.Bytes += int64(n)
+	}
+}
+
+// Stats returns statistics of the archive, listing up to largest of the
+// largest files, or none if largest is not positive. The sizes are final
+// once Close has returned.
+func (b *Builder) Stats(largest int) Stats {
+	stats := Stats{
+		Entries:           map[string]int{},
+		UncompressedBytes: b.stats.tarBytes.n,
+		ContentBytes:      b.stats.contentBytes,
+		LargestFiles:      []FileStats{},
+		Sources:           []*SourceStats{},
+	}
+	for typ, n := range b.stats.entries {
+		stats.Entries[typ] = n
+	}
+	for _, out := range b.stats.outputs {
+		stats.Outputs = append(stats.Outputs, OutputStats{
+			Path:        out.Path,
+			Compression: out.Compression,
+			Bytes:       out.counter.n,
+		})
+	}
+	for _, src := range b.stats.sources {
+		src := *src
+		stats.Sources = append(stats.Sources, &src)
+	}
+
+	files := make([]writtenFile, len(b.contents))
+	copy(files, b.contents)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].size > files[j].size
+	})
+	if largest < 0 {
+		largest = 0
+	}
+	if len(files) > largest {
+		files = files[:largest]
+	}
+	for _, f := range files {
+		fs := FileStats{Name: f.name, Bytes: f.size}
+		if f.source != nil {
+			fs.Source = f.source.String()
+		}
+		stats.LargestFiles = append(stats.LargestFiles, fs)
+	}
+	return stats
+}
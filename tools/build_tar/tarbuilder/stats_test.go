@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "big")
+	if err := ioutil.WriteFile(src, []byte(strings.Repeat("x", 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "lib/small", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "lib/medium", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "lib/link", Typeflag: tar.TypeSymlink, Linkname: "small", Mode: 0777},
+	}, map[string]string{"lib/small": "s", "lib/medium": strings.Repeat("m", 100)})
+
+	out := filepath.Join(dir, "out.tar.gz")
+	b, err := CreateAll([]Output{{Path: out, Compression: "gz"}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFiles([]FileInput{{Src: src, Dest: "big"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddTars([]string{in}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLink("usr/lib", "/lib"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := b.Stats(2)
+	if want := map[string]int{"file": 3, "dir": 2, "symlink": 2}; !reflect.DeepEqual(stats.Entries, want) {
+		t.Errorf("Entries = %v; want %v", stats.Entries, want)
+	}
+	if stats.ContentBytes != 1101 {
+		t.Errorf("ContentBytes = %d; want 1101", stats.ContentBytes)
+	}
+	// Seven 512-byte headers, the file contents padded to 512 bytes each and
+	// the 1024-byte trailer.
+	if stats.UncompressedBytes != 6656 {
+		t.Errorf("UncompressedBytes = %d; want 6656", stats.UncompressedBytes)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutputs := []OutputStats{{Path: out, Compression: "gz", Bytes: info.Size()}}
+	if !reflect.DeepEqual(stats.Outputs, wantOutputs) {
+		t.Errorf("Outputs = %+v; want %+v", stats.Outputs, wantOutputs)
+	}
+	wantLargest := []FileStats{
+		{Name: "big", Bytes: 1000, Source: "file"},
+		{Name: "lib/medium", Bytes: 100, Source: "tar:" + in},
+	}
+	if !reflect.DeepEqual(stats.LargestFiles, wantLargest) {
+		t.Errorf("LargestFiles = %+v; want %+v", stats.LargestFiles, wantLargest)
+	}
+	if got := b.Stats(-1).LargestFiles; len(got) != 0 {
+		t.Errorf("Stats(-1).LargestFiles = %+v; want none", got)
+	}
+	wantSources := []*SourceStats{
+		{Kind: "file", Entries: 1, Bytes: 1000},
+		{Kind: "tar", Path: in, Entries: 4, Bytes: 101},
+		{Kind: "link", Entries: 2},
+	}
+	if !reflect.DeepEqual(stats.Sources, wantSources) {
+		for _, s := range stats.Sources {
+			t.Logf("source %+v", *s)
+		}
+		t.Errorf("Sources differ")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// UpdateArchive adds the entries of the existing, possibly compressed tar
+// at path as they are, in their original order, except that each entry
+// whose path is the destination of one of inputs is replaced by that input.
+// The remaining inputs are then added as by AddFiles. When several inputs
+// have the same destination, the last one wins.
+//
+// path may be one of the outputs of CreateAll, to update it in place.
+func (b *Builder) UpdateArchive(path string, inputs []FileInput) error {
+	// replacements maps the final path of each input to the last input
+	// written there.
+	replacements := map[string]int{}
+	for i, in := range inputs {
+		if dest, ok := b.inputPath(in); ok {
+			replacements[dest] = i
+		}
+	}
+
+	replaced := map[int]bool{}
+	replace := func(name string) (bool, error) {
+		i, ok := replacements[name]
+		if !ok || replaced[i] {
+			return false, nil
+		}
+		replaced[i] = true
+		b.stats.setSource("file", "")
+		return true, b.addPrefetchedFile(readFileInput(inputs[i], b.stampValues, newPrefetchBudget(filePrefetchBytes)))
+	}
+	done := make(chan struct{})
+	defer close(done)
+	if err := b.addTarEntries(prefetchTar(path, newPrefetchBudget(tarPrefetchBytes), done), "", replace); err != nil {
+		return err
+	}
+
+	var rest []FileInput
+	for i, in := range inputs {
+		if replaced[i] {
+			continue
+		}
+		if dest, ok := b.inputPath(in); ok && replacements[dest] != i {
+			// A later input has the same destination.
+			continue
+		}
+		rest = append(rest, in)
+	}
+	return b.AddFiles(rest)
+}
+
+// inputPath returns the path in the archive of a file input, and whether
+// it is safe.
+func (b *Builder) inputPath(in FileInput) (string, bool) {
+	dest, err := cleanArchivePath(in.Dest)
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(strings.TrimLeft(b.directory, "/"), dest), true
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{"new-b": "new b", "newer-b": "newer b", "d": "d"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := writeTestTar(t, dir, "out.tar", []tar.Header{
+		{Name: "opt/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opt/a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "opt/b", Typeflag: tar.TypeReg, Mode: 0600, Uid: 1},
+		{Name: "opt/c", Typeflag: tar.TypeLink, Linkname: "opt/b", Mode: 0600},
+	}, map[string]string{"opt/a": "a", "opt/b": "b"})
+
+	// Update the archive in place.
+	b, err := Create(out, Options{Directory: "opt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.UpdateArchive(out, []FileInput{
+		{Src: filepath.Join(dir, "new-b"), Dest: "b"},
+		{Src: filepath.Join(dir, "d"), Dest: "d"},
+		{Src: filepath.Join(dir, "newer-b"), Dest: "/b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := []string{
+		"opt/ 5 0755 0:0",
+		"opt/a 0 0644 0:0 a",
+		"opt/b 0 0644 0:0 newer b",
+		"opt/c 1 0600 0:0 -> opt/b",
+		"opt/d 0 0644 0:0 d",
+	}
+	if got := readTestTar(t, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if _, err := os.Stat(out + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary output was left behind: %v", err)
+	}
+}
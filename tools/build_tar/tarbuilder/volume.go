@@ -0,0 +1,274 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// volumeSet tracks the volumes of an archive split by size.
+type volumeSet struct {
+	// max is the most bytes of tar stream in a volume, before compression.
+	max     int64
+	outputs []Output
+	// open creates the given volume of every output.
+	open func(volume int) (*openedOutputs, error)
+
+	// current is the volume being written, and start the number of bytes
+	// written to the archive before it.
+	current int
+	start   int64
+	// filling is set while the parent directories of the entry that
+	// started the current volume are written.
+	filling bool
+	// dirs maps each directory written to the archive to the header it was
+	// first written with, which makeDirs writes again in each volume that
+	// needs it. made holds the directories written to the current volume.
+	dirs map[string]tar.Header
+	made map[string]struct{}
+	// entryStart is the size of the archive before the entry being
+	// written and its parent directories.
+	entryStart int64
+	entries    []volumeEntry
+	// finished lists the outputs of the finished volumes, with their digest
+	// and signature files, for Abort to remove. pending lists those of the
+	// current volume.
+	finished []string
+	pending  []string
+	// located maps the cleaned path of each entry other than a directory
+	// to its volume.
+	located map[string]int
+}
+
+type volumeEntry struct {
+	name   string
+	volume int
+}
+
+// record adds an entry written to the current volume, with the cleaned
+// path name, to the index.
+func (v *volumeSet) record(name string, header *tar.Header) {
+	v.entries = append(v.entries, volumeEntry{name: header.Name, volume: v.current})
+	if header.Typeflag == tar.TypeDir {
+		return
+	}
+	if v.located == nil {
+		v.located = map[string]int{}
+	}
+	v.located[name] = v.current
+	if header.Typeflag != tar.TypeLink {
+		return
+	}
+	if v.inEarlierVolume(header.Linkname) {
+		klog.Warningf("Hardlink %s -> %s in volume %d points into an earlier volume, so it only extracts along with it", name, header.Linkname, v.current)
+	}
+}
+
+// inEarlierVolume returns whether the entry at the archive path name was
+// written to a volume before the current one.
+func (v *volumeSet) inEarlierVolume(name string) bool {
+	name, err := cleanArchivePath(name)
+	if err != nil {
+		return false
+	}
+	volume, ok := v.located[name]
+	return ok && volume != v.current
+}
+
+// volumePath returns the path of the given volume of the output at path,
+// numbering it before the ".tar" of the name: name.tar.gz becomes
+// name.000.tar.gz. Names without ".tar" are numbered before their
+// extension.
+func volumePath(path string, volume int) string {
+	dir, base := filepath.Split(path)
+	i := strings.LastIndex(base, ".tar")
+	if i <= 0 {
+		i = len(base) - len(filepath.Ext(base))
+	}
+	return fmt.Sprintf("%s%s.%03d%s", dir, base[:i], volume, base[i:])
+}
+
+// volumeIndexPath returns the path of the volume index of the output at
+// path, which is named after the whole output since several outputs may
+// share a stem: name.tar.gz has the index name.tar.gz.index.json.
+func volumeIndexPath(path string) string {
+	return path + ".index.json"
+}
+
+// entryBytes bounds the size of an entry with stored bytes of data in the
+// tar stream: its header, a PAX header if needed, and its padded data.
+func entryBytes(h *tar.Header, stored int64) int64 {
+	n := blockSize + stored + padding(stored)
+	if len(h.Name) > 100 || len(h.Linkname) > 100 || len(h.PAXRecords) > 0 {
+		records := int64(len(h.Name) + len(h.Linkname) + blockSize)
+		for k, v := range h.PAXRecords {
+			records += int64(len(k) + len(v) + 32)
+		}
+		n += blockSize + records + padding(records)
+	}
+	return n
+}
+
+// reserveEntry makes room in the current volume for the entry with header
+// and stored bytes of data, along with its missing parent directories dirs,
+// before any of them is written. This keeps the parents in the volume of
+// the entry, rather than ending the previous one.
+func (b *Builder) reserveEntry(header *tar.Header, stored int64, dirs []tar.Header) error {
+	v := b.volumes
+	if v == nil || v.filling {
+		return nil
+	}
+	var need int64
+	for i := range dirs {
+		need += entryBytes(&dirs[i], 0)
+	}
+	v.entryStart = -1
+	current := v.current
+	if err := b.reserveBytes(header, entryBytes(header, stored)+need); err != nil {
+		return err
+	}
+	v.entryStart = b.out.n
+	if v.current != current {
+		// The parents starting the new volume belong to the entry.
+		v.entryStart = v.start
+	}
+	return nil
+}
+
+// reserveVolume makes room in the current volume for header, followed by
+// stored bytes of data.
+func (b *Builder) reserveVolume(header *tar.Header, stored int64) error {
+	return b.reserveBytes(header, entryBytes(header, stored))
+}
+
+// reserveBytes makes room in the current volume for need bytes of entries
+// written for header. If they don't fit, it starts the next volume and
+// recreates the entry's parent directories in it. An entry too large for
+// any volume gets a volume of its own, along with its parents.
+func (b *Builder) reserveBytes(header *tar.Header, need int64) error {
+	v := b.volumes
+	if v == nil || v.filling {
+		return nil
+	}
+	// Write the padding of the previous entry so that it is counted.
+	if err := b.tw.Flush(); err != nil {
+		return err
+	}
+	// Leave room for the two zero blocks ending the archive.
+	need += 2 * blockSize
+	used := b.out.n - v.start
+	// Once an entry has a volume of its own, with its parents, another
+	// volume wouldn't hold it any better.
+	if v.entryStart == v.start {
+		return nil
+	}
+	if used == 0 || used+need <= v.max {
+		if need > v.max {
+			klog.Warningf("%s is larger than the maximum volume size of %d bytes", header.Name, v.max)
+		}
+		return nil
+	}
+	if err := b.nextVolume(); err != nil {
+		return err
+	}
+
+	v.filling = true
+	defer func() { v.filling = false }()
+	parent := *header
+	parent.Name = strings.TrimSuffix(header.Name, "/")
+	if err := b.makeDirs(parent, 0); err != nil {
+		return err
+	}
+	if used := b.out.n - v.start; used+need > v.max {
+		klog.Warningf("%s is larger than the maximum volume size of %d bytes", header.Name, v.max)
+	}
+	return nil
+}
+
+// nextVolume finishes the current volume and starts writing the next.
+func (b *Builder) nextVolume() error {
+	v := b.volumes
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i](); err != nil {
+			return &OutputError{Err: err}
+		}
+	}
+	b.closers = nil
+	v.finished = append(v.finished, v.pending...)
+
+	v.current++
+	b.tmpFiles = nil
+	opened, err := v.open(v.current)
+	if err != nil {
+		return &OutputError{Err: err}
+	}
+	// The counter under the tar writer carries over, so that it counts the
+	// whole archive.
+	b.out.w = outputWriter{opened.w}
+	b.tw = tar.NewWriter(b.out)
+	b.closers = append(opened.closers, b.tw.Close)
+	b.tmpFiles = opened.tmpFiles
+	b.stats.outputs = append(b.stats.outputs, opened.counters...)
+	v.pending = opened.files
+	v.start = b.out.n
+	// Each volume recreates the directories it needs.
+	v.made = map[string]struct{}{}
+	klog.V(2).Infof("Started volume %d", v.current)
+	return nil
+}
+
+// volumeIndex is the JSON index of the volumes of an output.
+type volumeIndex struct {
+	Volumes []string           `json:"volumes"`
+	Entries []volumeIndexEntry `json:"entries"`
+}
+
+type volumeIndexEntry struct {
+	Path   string `json:"path"`
+	Volume string `json:"volume"`
+}
+
+// writeVolumeIndexes writes the index of each output, mapping the path of
+// every entry to the volume holding it. Directories recreated in several
+// volumes are listed once per volume.
+func (b *Builder) writeVolumeIndexes() error {
+	v := b.volumes
+	for _, out := range v.outputs {
+		index := volumeIndex{Volumes: []string{}, Entries: []volumeIndexEntry{}}
+		for i := 0; i <= v.current; i++ {
+			index.Volumes = append(index.Volumes, filepath.Base(volumePath(out.Path, i)))
+		}
+		for _, e := range v.entries {
+			index.Entries = append(index.Entries, volumeIndexEntry{Path: e.name, Volume: index.Volumes[e.volume]})
+		}
+		data, err := json.MarshalIndent(index, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(volumeIndexPath(out.Path), append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
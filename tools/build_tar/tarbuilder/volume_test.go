@@ -0,0 +1,294 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarbuilder
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVolumePath(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		volume int
+		want   string
+	}{
+		{"out/layer.tar", 0, "out/layer.000.tar"},
+		{"out/layer.tar.gz", 12, "out/layer.012.tar.gz"},
+		{"a.tar.d/layer.tgz", 1, "a.tar.d/layer.001.tgz"},
+		{"layer", 2, "layer.002"},
+	} {
+		if got := volumePath(tc.path, tc.volume); got != tc.want {
+			t.Errorf("volumePath(%q, %d) = %q; want %q", tc.path, tc.volume, got, tc.want)
+		}
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, size := range map[string]int{"small": 100, "medium": 3000, "large": 20000} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const maxSize = 8 << 10
+	out := filepath.Join(dir, "out.tar")
+	b, err := Create(out, Options{Directory: "opt", MaxVolumeSize: maxSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.AddFiles([]FileInput{
+		{Src: filepath.Join(dir, "medium"), Dest: "a/1"},
+		{Src: filepath.Join(dir, "medium"), Dest: "a/2"},
+		{Src: filepath.Join(dir, "small"), Dest: "a/3"},
+		{Src: filepath.Join(dir, "large"), Dest: "b/large"},
+		{Src: filepath.Join(dir, "small"), Dest: "b/4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"opt/ 5 0755 0:0", "opt/a/ 5 0755 0:0", "opt/a/1 0 0644 0:0 " + strings.Repeat("x", 3000)},
+		{"opt/ 5 0755 0:0", "opt/a/ 5 0755 0:0", "opt/a/2 0 0644 0:0 " + strings.Repeat("x", 3000), "opt/a/3 0 0644 0:0 " + strings.Repeat("x", 100)},
+		{"opt/ 5 0755 0:0", "opt/b/ 5 0755 0:0", "opt/b/large 0 0644 0:0 " + strings.Repeat("x", 20000)},
+		{"opt/ 5 0755 0:0", "opt/b/ 5 0755 0:0", "opt/b/4 0 0644 0:0 " + strings.Repeat("x", 100)},
+	}
+	var volumes []string
+	wantIndex := volumeIndex{Volumes: []string{}}
+	for i, entries := range want {
+		path := volumePath(out, i)
+		volumes = append(volumes, path)
+		wantIndex.Volumes = append(wantIndex.Volumes, filepath.Base(path))
+		for _, e := range entries {
+			wantIndex.Entries = append(wantIndex.Entries, volumeIndexEntry{Path: strings.Fields(e)[0], Volume: filepath.Base(path)})
+		}
+	}
+	if got, _ := filepath.Glob(filepath.Join(dir, "out*.tar")); !reflect.DeepEqual(got, volumes) {
+		t.Errorf("got volumes %v; want %v", got, volumes)
+	}
+	for i, path := range volumes {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestTar(t, f); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("%s: got entries\n%s\nwant\n%s", path, strings.Join(got, "\n"), strings.Join(want[i], "\n"))
+		}
+		f.Close()
+		if info, err := os.Stat(path); err != nil || (i != 2 && info.Size() > maxSize) {
+			t.Errorf("%s: got size %v, %v; want at most %d", path, info.Size(), err, maxSize)
+		}
+	}
+
+	data, err := ioutil.ReadFile(out + ".index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index volumeIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(index, wantIndex) {
+		t.Errorf("got index %+v; want %+v", index, wantIndex)
+	}
+}
+
+func TestVolumeDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, size := range map[string]int{"small": 100, "large": 20000} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "out.tar")
+	b, err := Create(out, Options{MaxVolumeSize: 8 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.AddFiles([]FileInput{
+		{Src: filepath.Join(dir, "large"), Dest: "x/large"},
+		{Src: filepath.Join(dir, "small"), Dest: "y/small"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// x was written to the first volume, so it is a duplicate in the second.
+	if err := b.AddDir("x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"x/ 5 0755 0:0", "x/large 0 0644 0:0 " + strings.Repeat("x", 20000)},
+		{"y/ 5 0755 0:0", "y/small 0 0644 0:0 " + strings.Repeat("x", 100)},
+	}
+	checkVolumes(t, out, want)
+}
+
+func TestVolumeHardlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, filler := strings.Repeat("f", 3000), strings.Repeat("y", 5000)
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "a/file", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "a/same", Typeflag: tar.TypeLink, Linkname: "a/file", Mode: 0644},
+		{Name: "b/filler", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "d/back", Typeflag: tar.TypeLink, Linkname: "b/filler", Mode: 0644},
+		{Name: "c/link", Typeflag: tar.TypeLink, Linkname: "a/file", Mode: 0644},
+		{Name: "c/link2", Typeflag: tar.TypeLink, Linkname: "a/file", Mode: 0644},
+	}, map[string]string{"a/file": file, "b/filler": filler})
+
+	out := filepath.Join(dir, "out.tar")
+	b, err := Create(out, Options{Directory: "opt", MaxVolumeSize: 8 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddTar(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The hardlinks into earlier volumes are copies, so that each volume
+	// extracts on its own. d/back only fits in the next volume, so it is
+	// a copy too.
+	want := [][]string{
+		{"opt/ 5 0755 0:0", "opt/a/ 5 0755 0:0", "opt/a/file 0 0644 0:0 " + file, "opt/a/same 1 0644 0:0 -> opt/a/file"},
+		{"opt/ 5 0755 0:0", "opt/b/ 5 0755 0:0", "opt/b/filler 0 0644 0:0 " + filler},
+		{"opt/ 5 0755 0:0", "opt/d/ 5 0755 0:0", "opt/d/back 0 0644 0:0 " + filler},
+		{"opt/ 5 0755 0:0", "opt/c/ 5 0755 0:0", "opt/c/link 0 0644 0:0 " + file, "opt/c/link2 1 0644 0:0 -> opt/c/link"},
+	}
+	checkVolumes(t, out, want)
+}
+
+func TestVolumeDirHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Each file fills a volume along with its parents.
+	a, b := strings.Repeat("a", 5600), strings.Repeat("b", 5600)
+	in := writeTestTar(t, dir, "in.tar", []tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "usr/private/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "usr/private/a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/private/b", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/private/link", Typeflag: tar.TypeSymlink, Linkname: "a", Mode: 0777},
+	}, map[string]string{"usr/private/a": a, "usr/private/b": b})
+
+	out := filepath.Join(dir, "out.tar")
+	builder, err := Create(out, Options{MaxVolumeSize: 8 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddTar(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The parents are recreated as first written, rather than after the
+	// entry starting the volume.
+	want := [][]string{
+		{"usr/ 5 0755 0:0", "usr/private/ 5 0700 0:0", "usr/private/a 0 0644 0:0 " + a},
+		{"usr/ 5 0755 0:0", "usr/private/ 5 0700 0:0", "usr/private/b 0 0644 0:0 " + b},
+		{"usr/ 5 0755 0:0", "usr/private/ 5 0700 0:0", "usr/private/link 2 0777 0:0 -> a"},
+	}
+	checkVolumes(t, out, want)
+}
+
+func TestVolumeAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build_tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	large := filepath.Join(dir, "large")
+	if err := ioutil.WriteFile(large, []byte(strings.Repeat("x", 5000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := []Output{{Path: filepath.Join(dir, "out.tar"), Digests: []string{"sha256"}}}
+	b, err := CreateAll(outputs, Options{MaxVolumeSize: 8 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.AddFiles([]FileInput{
+		{Src: large, Dest: "1"},
+		{Src: large, Dest: "2"},
+		{Src: large, Dest: "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Abort()
+
+	// The finished volumes are removed along with the current one.
+	if got, _ := filepath.Glob(filepath.Join(dir, "out*")); len(got) != 0 {
+		t.Errorf("got %v after Abort; want no outputs", got)
+	}
+}
+
+// checkVolumes checks the entries of each volume of the output at out.
+func checkVolumes(t *testing.T, out string, want [][]string) {
+	t.Helper()
+	var volumes []string
+	for i := range want {
+		volumes = append(volumes, volumePath(out, i))
+	}
+	if got, _ := filepath.Glob(strings.TrimSuffix(out, ".tar") + ".*.tar"); !reflect.DeepEqual(got, volumes) {
+		t.Errorf("got volumes %v; want %v", got, volumes)
+	}
+	for i, path := range volumes {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestTar(t, f); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("%s: got entries\n%s\nwant\n%s", path, strings.Join(got, "\n"), strings.Join(want[i], "\n"))
+		}
+		f.Close()
+	}
+}